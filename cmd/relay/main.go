@@ -1,40 +1,161 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"log"
+	"log/slog"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/iguagile/iguagile-engine/iguagile"
 )
 
+// Maximum time to wait for rooms to be empty on shutdown.
+const shutdownTimeout = time.Minute
+
 func main() {
-	factory := &iguagile.RelayServiceFactory{}
+	var factory iguagile.RoomServiceFactory = &iguagile.RelayServiceFactory{}
+	if os.Getenv("ROOM_SERVICE") == "routing" {
+		factory = &iguagile.RoutingServiceFactory{}
+	}
+
 	address := os.Getenv("ROOM_HOST")
 	if address == "" {
 		address = "localhost:0"
 	}
 
-	store, err := iguagile.NewRedis(os.Getenv("REDIS_HOST"))
+	store, err := newStore(os.Getenv("REDIS_HOST"))
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer func() {
+		_ = store.Close()
+	}()
 
 	server, err := iguagile.NewRoomServer(factory, store, address)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	listener, err := net.Listen("tcp", address)
+	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+			log.Fatal(err)
+		}
+		server.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	}
+
+	tickets, err := newTicketVerifier(os.Getenv("TICKET_HMAC_KEYS"), os.Getenv("TICKET_ED25519_KEYS"))
 	if err != nil {
 		log.Fatal(err)
 	}
+	server.Tickets = tickets
+	server.RequireTicket = os.Getenv("REQUIRE_TICKET") == "true"
 
 	port, err := strconv.Atoi(os.Getenv("GRPC_PORT"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Fatal(server.Run(listener, port))
+	if metricsPort := os.Getenv("METRICS_PORT"); metricsPort != "" {
+		listener, err := net.Listen("tcp", ":"+metricsPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			if err := server.ServeMetrics(listener); err != nil && !errors.Is(err, iguagile.ErrServerClosed) {
+				log.Println(err)
+			}
+		}()
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if err := run(server, address, port); err != nil && !errors.Is(err, iguagile.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// newStore connects to redis, or uses the in-memory store for a single node deployment if no redis host is given.
+func newStore(redisHost string) (iguagile.Store, error) {
+	if redisHost == "" {
+		return iguagile.NewMemoryStore(), nil
+	}
+
+	return iguagile.NewRedis(redisHost)
+}
+
+// newTicketVerifier returns the verifier of join tickets with the comma separated kid=key pairs,
+// or nil if no keys are given. Ed25519 public keys are base64 encoded.
+func newTicketVerifier(hmacKeys, ed25519Keys string) (*iguagile.TicketVerifier, error) {
+	if hmacKeys == "" && ed25519Keys == "" {
+		return nil, nil
+	}
+
+	verifier, err := iguagile.NewTicketVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pair := range strings.Split(hmacKeys, ",") {
+		if id, secret, ok := strings.Cut(pair, "="); ok {
+			if err := verifier.AddKey(iguagile.TicketKey{ID: id, Secret: []byte(secret)}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, pair := range strings.Split(ed25519Keys, ",") {
+		if id, key, ok := strings.Cut(pair, "="); ok {
+			publicKey, err := base64.StdEncoding.DecodeString(key)
+			if err != nil {
+				return nil, err
+			}
+			if err := verifier.AddKey(iguagile.TicketKey{ID: id, PublicKey: publicKey}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return verifier, nil
+}
+
+func run(server *iguagile.RoomServer, address string, port int) error {
+	if os.Getenv("ROOM_TRANSPORT") == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return err
+		}
+
+		return server.RunUDP(conn, port)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv("ROOM_TRANSPORT") == "websocket" {
+		return server.RunWebSocket(listener, port)
+	}
+
+	return server.Run(listener, port)
 }
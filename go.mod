@@ -1,6 +1,6 @@
 module github.com/iguagile/iguagile-engine
 
-go 1.19
+go 1.21
 
 require (
 	github.com/golang/protobuf v1.5.3
@@ -8,14 +8,14 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/iguagile/iguagile-room-proto v0.0.0-20230709141737-b58cae5f0141
 	github.com/minami14/idgo v1.1.1
+	golang.org/x/net v0.9.0
 	google.golang.org/grpc v1.56.2
+	google.golang.org/protobuf v1.30.0
 )
 
 require (
 	github.com/minami14/go-bitarray v1.1.2 // indirect
-	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
-	google.golang.org/protobuf v1.30.0 // indirect
 )
@@ -0,0 +1,262 @@
+package iguagile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/iguagile/iguagile-room-proto/room"
+)
+
+// traffic counts messages and bytes.
+type traffic struct {
+	messages atomic.Uint64
+	bytes    atomic.Uint64
+}
+
+func (t *traffic) add(size int) {
+	t.messages.Add(1)
+	t.bytes.Add(uint64(size))
+}
+
+// remoteHost returns the host of the peer, or an empty string if it is unknown.
+func remoteHost(conn io.ReadWriteCloser) string {
+	address := remoteAddress(conn)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// remoteAddress returns the address of the peer of the connection, or empty if the transport does not have it.
+func remoteAddress(conn io.ReadWriteCloser) string {
+	switch c := conn.(type) {
+	case *webSocketConn:
+		return c.Request().RemoteAddr
+	case *udpConn:
+		return c.addr.String()
+	case net.Conn:
+		return c.RemoteAddr().String()
+	default:
+		return ""
+	}
+}
+
+// authorize checks the token is the api token of the server.
+func (s *RoomServer) authorize(token []byte) error {
+	if !bytes.Equal(token, s.serverProto.Token) {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// room returns the room on the server.
+func (s *RoomServer) room(roomID int32) (*Room, error) {
+	r, ok := s.rooms.Load(int(roomID))
+	if !ok {
+		return nil, fmt.Errorf("the room does not exist %v", roomID)
+	}
+
+	room, ok := r.(*Room)
+	if !ok {
+		return nil, fmt.Errorf("invalid type %T", r)
+	}
+	return room, nil
+}
+
+// ListRooms lists rooms on the server.
+func (s *RoomServer) ListRooms(ctx context.Context, request *ListRoomsRequest) (*ListRoomsResponse, error) {
+	if err := s.authorize(request.ServerToken); err != nil {
+		return nil, err
+	}
+
+	var rooms []*pb.Room
+	s.rooms.Range(func(_, value interface{}) bool {
+		room, ok := value.(*Room)
+		if !ok {
+			return true
+		}
+
+		_ = room.do(func() {
+			rooms = append(rooms, proto.Clone(room.roomProto).(*pb.Room))
+		})
+		return true
+	})
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomId < rooms[j].RoomId
+	})
+	return &ListRoomsResponse{Rooms: rooms}, nil
+}
+
+// GetRoom gets details of the room.
+func (s *RoomServer) GetRoom(ctx context.Context, request *GetRoomRequest) (*RoomDetail, error) {
+	if err := s.authorize(request.ServerToken); err != nil {
+		return nil, err
+	}
+
+	room, err := s.room(request.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	var detail *RoomDetail
+	if err := room.do(func() { detail = room.detail() }); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// CloseRoom closes the room and sends the reason to the clients.
+func (s *RoomServer) CloseRoom(ctx context.Context, request *CloseRoomRequest) (*AdminResponse, error) {
+	if err := s.authorize(request.ServerToken); err != nil {
+		return nil, err
+	}
+
+	room, err := s.room(request.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	room.log.Info("close room", "reason", request.Reason)
+	if err := room.closeWithMessage(systemMessage(RoomClosing, []byte(request.Reason)), request.Reason, shutdownWriteTimeout); err != nil {
+		return nil, err
+	}
+	return &AdminResponse{}, nil
+}
+
+// KickClient kicks the client from the room and sends the reason to the client.
+func (s *RoomServer) KickClient(ctx context.Context, request *KickClientRequest) (*AdminResponse, error) {
+	if err := s.authorize(request.ServerToken); err != nil {
+		return nil, err
+	}
+
+	room, err := s.room(request.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if e := room.do(func() { err = room.kick(int(request.ClientId), request.Ban, request.Reason) }); e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &AdminResponse{}, nil
+}
+
+// UpdateRoom updates the config of the room and registers the room to the store.
+func (s *RoomServer) UpdateRoom(ctx context.Context, request *UpdateRoomRequest) (*pb.Room, error) {
+	if err := s.authorize(request.ServerToken); err != nil {
+		return nil, err
+	}
+
+	room, err := s.room(request.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *pb.Room
+	if e := room.do(func() { updated, err = room.update(request) }); e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// detail returns details of the room.
+func (r *Room) detail() *RoomDetail {
+	clients := r.clients()
+	ids := make([]int32, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, int32(client.GetID()))
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	hostID := int32(-1)
+	if r.host != nil {
+		hostID = int32(r.host.GetID())
+	}
+
+	return &RoomDetail{
+		Room:             proto.Clone(r.roomProto).(*pb.Room),
+		ClientIds:        ids,
+		HostId:           hostID,
+		UptimeMillis:     time.Since(r.createdAt).Milliseconds(),
+		ReceivedMessages: r.inbound.messages.Load(),
+		ReceivedBytes:    r.inbound.bytes.Load(),
+		SentMessages:     r.outbound.messages.Load(),
+		SentBytes:        r.outbound.bytes.Load(),
+	}
+}
+
+// kick sends the reason to the client and closes the connection after it is written.
+// If ban is true, the user of the client is rejected when it joins the room again,
+// or the host of the client if it has no authenticated identity.
+func (r *Room) kick(clientID int, ban bool, reason string) error {
+	client, err := r.clientManager.Get(clientID)
+	if err != nil {
+		return err
+	}
+
+	if ban {
+		if identity := client.Identity(); identity != nil && identity.UserID != "" {
+			r.bannedUsers[identity.UserID] = true
+		} else if host := remoteHost(client.conn()); host != "" {
+			r.banned[host] = true
+		} else {
+			r.log.Warn("the host of the client is unknown", "client_id", clientID)
+		}
+	}
+
+	client.Send(systemMessage(Kicked, []byte(reason)))
+	client.closeAfterWrite()
+	return nil
+}
+
+// update updates the config of the room with the fields of the request.
+func (r *Room) update(request *UpdateRoomRequest) (*pb.Room, error) {
+	for _, field := range request.Fields {
+		switch field {
+		case "information":
+		case "max_user":
+			if request.MaxUser <= 0 {
+				return nil, fmt.Errorf("invalid max user %v", request.MaxUser)
+			}
+		case "password":
+		default:
+			return nil, fmt.Errorf("unknown field %v", field)
+		}
+	}
+
+	for _, field := range request.Fields {
+		switch field {
+		case "information":
+			r.config.Info = request.Information
+			r.roomProto.Information = request.Information
+		case "max_user":
+			r.config.MaxUser = int(request.MaxUser)
+			r.roomProto.MaxUser = request.MaxUser
+		case "password":
+			r.config.Password = request.Password
+			r.roomProto.RequirePassword = request.Password != ""
+		}
+	}
+
+	if r.creatorConnected.Load() {
+		r.registerRoom()
+	}
+
+	return proto.Clone(r.roomProto).(*pb.Room), nil
+}
@@ -0,0 +1,333 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: admin.proto
+
+package iguagile
+
+import (
+	context "context"
+	room "github.com/iguagile/iguagile-room-proto/room"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	RoomAdminService_ListRooms_FullMethodName  = "/iguagile.RoomAdminService/ListRooms"
+	RoomAdminService_GetRoom_FullMethodName    = "/iguagile.RoomAdminService/GetRoom"
+	RoomAdminService_CloseRoom_FullMethodName  = "/iguagile.RoomAdminService/CloseRoom"
+	RoomAdminService_KickClient_FullMethodName = "/iguagile.RoomAdminService/KickClient"
+	RoomAdminService_UpdateRoom_FullMethodName = "/iguagile.RoomAdminService/UpdateRoom"
+	RoomAdminService_WatchRooms_FullMethodName = "/iguagile.RoomAdminService/WatchRooms"
+)
+
+// RoomAdminServiceClient is the client API for RoomAdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RoomAdminServiceClient interface {
+	// ListRooms lists rooms on the server.
+	ListRooms(ctx context.Context, in *ListRoomsRequest, opts ...grpc.CallOption) (*ListRoomsResponse, error)
+	// GetRoom gets details of the room.
+	GetRoom(ctx context.Context, in *GetRoomRequest, opts ...grpc.CallOption) (*RoomDetail, error)
+	// CloseRoom closes the room.
+	CloseRoom(ctx context.Context, in *CloseRoomRequest, opts ...grpc.CallOption) (*AdminResponse, error)
+	// KickClient kicks the client from the room.
+	KickClient(ctx context.Context, in *KickClientRequest, opts ...grpc.CallOption) (*AdminResponse, error)
+	// UpdateRoom updates the config of the room.
+	UpdateRoom(ctx context.Context, in *UpdateRoomRequest, opts ...grpc.CallOption) (*room.Room, error)
+	// WatchRooms watches events of rooms on the server.
+	WatchRooms(ctx context.Context, in *WatchRoomsRequest, opts ...grpc.CallOption) (RoomAdminService_WatchRoomsClient, error)
+}
+
+type roomAdminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRoomAdminServiceClient(cc grpc.ClientConnInterface) RoomAdminServiceClient {
+	return &roomAdminServiceClient{cc}
+}
+
+func (c *roomAdminServiceClient) ListRooms(ctx context.Context, in *ListRoomsRequest, opts ...grpc.CallOption) (*ListRoomsResponse, error) {
+	out := new(ListRoomsResponse)
+	err := c.cc.Invoke(ctx, RoomAdminService_ListRooms_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *roomAdminServiceClient) GetRoom(ctx context.Context, in *GetRoomRequest, opts ...grpc.CallOption) (*RoomDetail, error) {
+	out := new(RoomDetail)
+	err := c.cc.Invoke(ctx, RoomAdminService_GetRoom_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *roomAdminServiceClient) CloseRoom(ctx context.Context, in *CloseRoomRequest, opts ...grpc.CallOption) (*AdminResponse, error) {
+	out := new(AdminResponse)
+	err := c.cc.Invoke(ctx, RoomAdminService_CloseRoom_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *roomAdminServiceClient) KickClient(ctx context.Context, in *KickClientRequest, opts ...grpc.CallOption) (*AdminResponse, error) {
+	out := new(AdminResponse)
+	err := c.cc.Invoke(ctx, RoomAdminService_KickClient_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *roomAdminServiceClient) UpdateRoom(ctx context.Context, in *UpdateRoomRequest, opts ...grpc.CallOption) (*room.Room, error) {
+	out := new(room.Room)
+	err := c.cc.Invoke(ctx, RoomAdminService_UpdateRoom_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *roomAdminServiceClient) WatchRooms(ctx context.Context, in *WatchRoomsRequest, opts ...grpc.CallOption) (RoomAdminService_WatchRoomsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &RoomAdminService_ServiceDesc.Streams[0], RoomAdminService_WatchRooms_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &roomAdminServiceWatchRoomsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RoomAdminService_WatchRoomsClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type roomAdminServiceWatchRoomsClient struct {
+	grpc.ClientStream
+}
+
+func (x *roomAdminServiceWatchRoomsClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RoomAdminServiceServer is the server API for RoomAdminService service.
+// All implementations should embed UnimplementedRoomAdminServiceServer
+// for forward compatibility
+type RoomAdminServiceServer interface {
+	// ListRooms lists rooms on the server.
+	ListRooms(context.Context, *ListRoomsRequest) (*ListRoomsResponse, error)
+	// GetRoom gets details of the room.
+	GetRoom(context.Context, *GetRoomRequest) (*RoomDetail, error)
+	// CloseRoom closes the room.
+	CloseRoom(context.Context, *CloseRoomRequest) (*AdminResponse, error)
+	// KickClient kicks the client from the room.
+	KickClient(context.Context, *KickClientRequest) (*AdminResponse, error)
+	// UpdateRoom updates the config of the room.
+	UpdateRoom(context.Context, *UpdateRoomRequest) (*room.Room, error)
+	// WatchRooms watches events of rooms on the server.
+	WatchRooms(*WatchRoomsRequest, RoomAdminService_WatchRoomsServer) error
+}
+
+// UnimplementedRoomAdminServiceServer should be embedded to have forward compatible implementations.
+type UnimplementedRoomAdminServiceServer struct {
+}
+
+func (UnimplementedRoomAdminServiceServer) ListRooms(context.Context, *ListRoomsRequest) (*ListRoomsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRooms not implemented")
+}
+func (UnimplementedRoomAdminServiceServer) GetRoom(context.Context, *GetRoomRequest) (*RoomDetail, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoom not implemented")
+}
+func (UnimplementedRoomAdminServiceServer) CloseRoom(context.Context, *CloseRoomRequest) (*AdminResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseRoom not implemented")
+}
+func (UnimplementedRoomAdminServiceServer) KickClient(context.Context, *KickClientRequest) (*AdminResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KickClient not implemented")
+}
+func (UnimplementedRoomAdminServiceServer) UpdateRoom(context.Context, *UpdateRoomRequest) (*room.Room, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateRoom not implemented")
+}
+func (UnimplementedRoomAdminServiceServer) WatchRooms(*WatchRoomsRequest, RoomAdminService_WatchRoomsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRooms not implemented")
+}
+
+// UnsafeRoomAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RoomAdminServiceServer will
+// result in compilation errors.
+type UnsafeRoomAdminServiceServer interface {
+	mustEmbedUnimplementedRoomAdminServiceServer()
+}
+
+func RegisterRoomAdminServiceServer(s grpc.ServiceRegistrar, srv RoomAdminServiceServer) {
+	s.RegisterService(&RoomAdminService_ServiceDesc, srv)
+}
+
+func _RoomAdminService_ListRooms_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRoomsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoomAdminServiceServer).ListRooms(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoomAdminService_ListRooms_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoomAdminServiceServer).ListRooms(ctx, req.(*ListRoomsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoomAdminService_GetRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoomAdminServiceServer).GetRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoomAdminService_GetRoom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoomAdminServiceServer).GetRoom(ctx, req.(*GetRoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoomAdminService_CloseRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseRoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoomAdminServiceServer).CloseRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoomAdminService_CloseRoom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoomAdminServiceServer).CloseRoom(ctx, req.(*CloseRoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoomAdminService_KickClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KickClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoomAdminServiceServer).KickClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoomAdminService_KickClient_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoomAdminServiceServer).KickClient(ctx, req.(*KickClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoomAdminService_UpdateRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoomAdminServiceServer).UpdateRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoomAdminService_UpdateRoom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoomAdminServiceServer).UpdateRoom(ctx, req.(*UpdateRoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoomAdminService_WatchRooms_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRoomsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RoomAdminServiceServer).WatchRooms(m, &roomAdminServiceWatchRoomsServer{stream})
+}
+
+type RoomAdminService_WatchRoomsServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type roomAdminServiceWatchRoomsServer struct {
+	grpc.ServerStream
+}
+
+func (x *roomAdminServiceWatchRoomsServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// RoomAdminService_ServiceDesc is the grpc.ServiceDesc for RoomAdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RoomAdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "iguagile.RoomAdminService",
+	HandlerType: (*RoomAdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListRooms",
+			Handler:    _RoomAdminService_ListRooms_Handler,
+		},
+		{
+			MethodName: "GetRoom",
+			Handler:    _RoomAdminService_GetRoom_Handler,
+		},
+		{
+			MethodName: "CloseRoom",
+			Handler:    _RoomAdminService_CloseRoom_Handler,
+		},
+		{
+			MethodName: "KickClient",
+			Handler:    _RoomAdminService_KickClient_Handler,
+		},
+		{
+			MethodName: "UpdateRoom",
+			Handler:    _RoomAdminService_UpdateRoom_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRooms",
+			Handler:       _RoomAdminService_WatchRooms_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "admin.proto",
+}
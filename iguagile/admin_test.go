@@ -0,0 +1,189 @@
+package iguagile
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestRoomAdminService(t *testing.T) {
+	store := NewMemoryStore()
+	server, err := NewRoomServer(RoutingServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	RegisterRoomAdminServiceServer(grpcServer, server)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	cc, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cc.Close()
+	}()
+	admin := NewRoomAdminServiceClient(cc)
+	ctx := context.Background()
+	token := server.serverProto.Token
+
+	if _, err := admin.ListRooms(ctx, &ListRoomsRequest{ServerToken: []byte("invalid")}); err == nil {
+		t.Error("invalid token is accepted")
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostID := binary.LittleEndian.Uint16(receiveOutbound(t, host).ID)
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, guest)
+	guestID := binary.LittleEndian.Uint16(receiveOutbound(t, host).ID)
+
+	if err := send(host, append([]byte{OtherClients, UserMessage}, testData...)); err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, guest)
+
+	list, err := admin.ListRooms(ctx, &ListRoomsRequest{ServerToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Rooms) != 1 || list.Rooms[0].RoomId != roomID || list.Rooms[0].ConnectedUser != 2 {
+		t.Errorf("invalid rooms %v", list.Rooms)
+	}
+
+	detail, err := admin.GetRoom(ctx, &GetRoomRequest{ServerToken: token, RoomId: roomID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.ClientIds) != 2 || detail.HostId != int32(hostID) || detail.ReceivedMessages != 1 || detail.SentMessages == 0 {
+		t.Errorf("invalid detail %v", detail)
+	}
+
+	// The banned client cannot join again.
+	if _, err := admin.KickClient(ctx, &KickClientRequest{ServerToken: token, RoomId: roomID, ClientId: int32(guestID), Ban: true, Reason: "cheat"}); err != nil {
+		t.Fatal(err)
+	}
+	if data := receiveOutbound(t, guest); data.MessageType != Kicked || string(data.Payload) != "cheat" {
+		t.Errorf("invalid data %v", data)
+	}
+	if exit := receiveOutbound(t, host); exit.MessageType != ExitConnect {
+		t.Errorf("invalid data %v", exit)
+	}
+	if _, err := connect(server, false); err == nil {
+		t.Error("banned client joined the room")
+	}
+
+	r, err := store.Room(roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ConnectedUser != 1 {
+		t.Errorf("connected users are not updated %v", r)
+	}
+
+	if _, err := admin.UpdateRoom(ctx, &UpdateRoomRequest{ServerToken: token, RoomId: roomID, Fields: []string{"name"}}); err == nil {
+		t.Error("unknown field is updated")
+	}
+
+	updated, err := admin.UpdateRoom(ctx, &UpdateRoomRequest{
+		ServerToken: token,
+		RoomId:      roomID,
+		Fields:      []string{"max_user", "information"},
+		MaxUser:     4,
+		Information: map[string]string{"map": "desert"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.MaxUser != 4 || updated.Information["map"] != "desert" || !updated.RequirePassword {
+		t.Errorf("invalid room %v", updated)
+	}
+	if r, err := store.Room(roomID); err != nil || r.MaxUser != 4 {
+		t.Errorf("updated room is not registered %v %v", r, err)
+	}
+
+	if _, err := admin.CloseRoom(ctx, &CloseRoomRequest{ServerToken: token, RoomId: roomID, Reason: "maintenance"}); err != nil {
+		t.Fatal(err)
+	}
+	if data := receiveOutbound(t, host); data.MessageType != RoomClosing || string(data.Payload) != "maintenance" {
+		t.Errorf("invalid data %v", data)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := store.Room(roomID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("closed room is registered %v", err)
+	}
+	if _, err := admin.GetRoom(ctx, &GetRoomRequest{ServerToken: token, RoomId: roomID}); err == nil {
+		t.Error("closed room is found")
+	}
+}
+
+func TestKickClientBan(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Authenticator = &sessionAuthenticator{
+		sessions: map[string]string{"host": "host", "guest": "guest", "other": "other"},
+		requests: make(chan *AuthRequest, 10),
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(session string) *HandshakeReply {
+		request := &HandshakeRequest{RoomID: roomID, ApplicationName: appName, Version: appVersion, AuthPayload: []byte(session)}
+		conn, reply := joinVersioned(t, server, request.Bytes())
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		return reply
+	}
+
+	join("host")
+	reply := join("guest")
+	if reply.Status != HandshakeOK {
+		t.Fatalf("invalid reply %v", reply)
+	}
+
+	if err := room.do(func() { err = room.kick(reply.ClientID, true, "cheat") }); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The authenticated user is banned rather than the host shared with other users.
+	if reply := join("guest"); reply.Status != HandshakeBanned {
+		t.Errorf("invalid reply %v", reply)
+	}
+	if reply := join("other"); reply.Status != HandshakeOK {
+		t.Errorf("invalid reply %v", reply)
+	}
+}
@@ -0,0 +1,136 @@
+package iguagile
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// Authenticator decides whether the peer is admitted to the room.
+// It is called for every handshake after the room is found, and may be called concurrently.
+type Authenticator interface {
+	// Authenticate returns the decision for the request.
+	// The error is the failure of the authenticator itself, and the peer is rejected without the detail.
+	Authenticate(ctx context.Context, request *AuthRequest) (*AuthDecision, error)
+}
+
+// AuthRequest is the request to join the room.
+type AuthRequest struct {
+	// Room is the config of the room the peer joins.
+	Room RoomConfig
+	// RemoteAddr is the address of the peer, or empty if the transport does not have it.
+	RemoteAddr string
+	// Creator is true if the peer joins as the creator of the room.
+	Creator bool
+	// Resume is true if the peer resumes the session.
+	Resume   bool
+	Password string
+	Token    []byte
+	Ticket   []byte
+	// Payload is the auth payload of the handshake, which is interpreted by the Authenticator.
+	Payload []byte
+}
+
+// AuthDecision is the decision of the Authenticator.
+type AuthDecision struct {
+	// Reason rejects the peer if it is not nil, and is replied to the peer.
+	// ErrInvalidPassword, ErrInvalidRoomToken, ErrInvalidTicket and ErrTicketExpired are replied with their own status codes,
+	// and other reasons with HandshakeUnauthorized.
+	Reason error
+	// Identity is the verified identity of the accepted peer, or nil if the peer is anonymous.
+	Identity *Identity
+}
+
+// Accept returns the decision admits the peer with the identity.
+func Accept(identity *Identity) *AuthDecision {
+	return &AuthDecision{Identity: identity}
+}
+
+// Reject returns the decision rejects the peer with the reason.
+func Reject(reason error) *AuthDecision {
+	return &AuthDecision{Reason: reason}
+}
+
+// Errors of the DefaultAuthenticator
+var (
+	ErrInvalidPassword  = errors.New("invalid password")
+	ErrInvalidRoomToken = errors.New("invalid room token")
+	ErrTicketRequired   = errors.New("join ticket is required")
+)
+
+// DefaultAuthenticator admits the peer with the password of the room, and the creator with the room token.
+// The join ticket verified with Tickets admits the peer in place of them.
+type DefaultAuthenticator struct {
+	// Tickets verifies join tickets. Join tickets are not accepted if it is nil.
+	Tickets *TicketVerifier
+	// RequireTicket rejects the peer without the join ticket.
+	RequireTicket bool
+}
+
+// Authenticate for implement Authenticator.
+func (a *DefaultAuthenticator) Authenticate(_ context.Context, request *AuthRequest) (*AuthDecision, error) {
+	if len(request.Ticket) > 0 || a.RequireTicket {
+		identity, err := a.verifyTicket(request)
+		if err != nil {
+			return Reject(err), nil
+		}
+		return Accept(identity), nil
+	}
+
+	if request.Room.Password != "" &&
+		subtle.ConstantTimeCompare([]byte(request.Password), []byte(request.Room.Password)) != 1 {
+		return Reject(ErrInvalidPassword), nil
+	}
+
+	if request.Creator && subtle.ConstantTimeCompare(request.Token, request.Room.Token) != 1 {
+		return Reject(ErrInvalidRoomToken), nil
+	}
+
+	return Accept(nil), nil
+}
+
+// verifyTicket returns the identity of the join ticket for the room.
+func (a *DefaultAuthenticator) verifyTicket(request *AuthRequest) (*Identity, error) {
+	if len(request.Ticket) == 0 {
+		return nil, ErrTicketRequired
+	}
+
+	if a.Tickets == nil {
+		return nil, fmt.Errorf("%w join tickets are not accepted", ErrInvalidTicket)
+	}
+
+	ticket, err := a.Tickets.Verify(request.Ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	if ticket.RoomID != request.Room.RoomID {
+		return nil, fmt.Errorf("%w room %v", ErrInvalidTicket, ticket.RoomID)
+	}
+
+	if request.Creator && ticket.Role != TicketRoleCreator {
+		return nil, fmt.Errorf("%w the join ticket is not for the creator", ErrInvalidRoomToken)
+	}
+
+	return &Identity{
+		UserID:      ticket.UserID,
+		Role:        ticket.Role,
+		DisplayName: ticket.DisplayName,
+		Properties:  ticket.Properties,
+	}, nil
+}
+
+// authFailure returns the reason of the handshake failure for the rejection of the Authenticator.
+func authFailure(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidPassword):
+		return failedPassword
+	case errors.Is(err, ErrInvalidRoomToken):
+		return failedToken
+	case errors.Is(err, ErrInvalidTicket), errors.Is(err, ErrTicketExpired), errors.Is(err, ErrTicketRequired):
+		return failedTicket
+	default:
+		return failedAuth
+	}
+}
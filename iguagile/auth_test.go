@@ -0,0 +1,118 @@
+package iguagile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// sessionAuthenticator admits peers whose auth payload is a known session.
+type sessionAuthenticator struct {
+	sessions map[string]string
+	requests chan *AuthRequest
+}
+
+func (a *sessionAuthenticator) Authenticate(_ context.Context, request *AuthRequest) (*AuthDecision, error) {
+	a.requests <- request
+	if bytes.Equal(request.Payload, []byte("broken")) {
+		return nil, errors.New("auth service is down")
+	}
+
+	userID, ok := a.sessions[string(request.Payload)]
+	if !ok {
+		return Reject(errors.New("unknown session")), nil
+	}
+	return Accept(&Identity{UserID: userID}), nil
+}
+
+func TestAuthenticator(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := &sessionAuthenticator{
+		sessions: map[string]string{"session": "user"},
+		requests: make(chan *AuthRequest, 1),
+	}
+	server.Authenticator = authenticator
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(payload string) *HandshakeReply {
+		request := &HandshakeRequest{RoomID: roomID, ApplicationName: appName, Version: appVersion, AuthPayload: []byte(payload)}
+		conn, reply := joinVersioned(t, server, request.Bytes())
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		return reply
+	}
+
+	// The authenticator decides in place of the password and the token.
+	reply := join("session")
+	if reply.Status != HandshakeOK {
+		t.Fatalf("invalid reply %v", reply)
+	}
+
+	request := <-authenticator.requests
+	if !request.Creator || request.RemoteAddr == "" || request.Room.RoomID != roomID || string(request.Payload) != "session" {
+		t.Errorf("invalid request %v", request)
+	}
+
+	var identity *Identity
+	if err := room.do(func() { identity, err = room.Identity(reply.ClientID) }); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil || identity == nil || identity.UserID != "user" {
+		t.Errorf("invalid identity %v %v", identity, err)
+	}
+
+	reply = join("other")
+	<-authenticator.requests
+	if reply.Status != HandshakeUnauthorized || reply.Message != "unknown session" {
+		t.Errorf("invalid reply %v", reply)
+	}
+
+	// The failure of the authenticator is not replied.
+	reply = join("broken")
+	<-authenticator.requests
+	if reply.Status != HandshakeUnauthorized || strings.Contains(reply.Message, "down") {
+		t.Errorf("invalid reply %v", reply)
+	}
+}
+
+func TestDefaultAuthenticator(t *testing.T) {
+	authenticator := &DefaultAuthenticator{}
+	config := RoomConfig{RoomID: roomID, Password: password, Token: roomToken}
+
+	tests := []struct {
+		request *AuthRequest
+		reason  error
+	}{
+		{&AuthRequest{Room: config, Password: password}, nil},
+		{&AuthRequest{Room: config, Password: "wrong"}, ErrInvalidPassword},
+		{&AuthRequest{Room: config, Password: password, Creator: true, Token: roomToken}, nil},
+		{&AuthRequest{Room: config, Password: password, Creator: true}, ErrInvalidRoomToken},
+		{&AuthRequest{Room: config, Ticket: []byte("ticket")}, ErrInvalidTicket},
+	}
+	for _, test := range tests {
+		decision, err := authenticator.Authenticate(context.Background(), test.request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(decision.Reason, test.reason) {
+			t.Errorf("invalid decision %v %v", decision.Reason, test.reason)
+		}
+	}
+
+	authenticator.RequireTicket = true
+	decision, err := authenticator.Authenticate(context.Background(), &AuthRequest{Room: config, Password: password})
+	if err != nil || !errors.Is(decision.Reason, ErrTicketRequired) {
+		t.Errorf("invalid decision %v %v", decision, err)
+	}
+}
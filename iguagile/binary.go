@@ -12,6 +12,43 @@ const (
 const (
 	NewConnect = iota
 	ExitConnect
+	Instantiate
+	Destroy
+	ChangeOwner
+	RequestOwnership
+	GrantOwnership
+	DenyOwnership
+	TransferOwnership
+	UpdateObject
+	BufferedRPC
+	RemoveBufferedRPC
+	ClearBufferedRPC
+	ChangeHost
+	RequestHost
+	TransferHost
+	DesignateHost
+	ServerClosing
+	RoomClosing
+	Kicked
+	Ping
+	Pong
+	ClientIdentity
+
+	// UserMessage is the first message type not interpreted by the engine.
+	UserMessage = 32
+)
+
+// Delivery modes are stored in the upper bits of the message type byte.
+// Transports preserving message order and reliability by themselves ignore them.
+const (
+	ReliableOrdered = iota
+	Unreliable
+	UnreliableSequenced
+)
+
+const (
+	deliveryShift   = 6
+	messageTypeMask = 1<<deliveryShift - 1
 )
 
 // BinaryData is client and server data transfer format.
@@ -20,6 +57,7 @@ type BinaryData struct {
 	ID          []byte
 	Target      byte
 	MessageType byte
+	Delivery    byte
 	Payload     []byte
 }
 
@@ -35,7 +73,8 @@ func NewInBoundData(b []byte) (*BinaryData, error) {
 	return &BinaryData{
 		Traffic:     Inbound,
 		Target:      b[0],
-		MessageType: b[1],
+		MessageType: b[1] & messageTypeMask,
+		Delivery:    b[1] >> deliveryShift,
 		Payload:     b[2:],
 	}, nil
 }
@@ -49,7 +88,8 @@ func NewOutBoundData(b []byte) (*BinaryData, error) {
 	return &BinaryData{
 		Traffic:     Outbound,
 		ID:          b[:2],
-		MessageType: b[2],
+		MessageType: b[2] & messageTypeMask,
+		Delivery:    b[2] >> deliveryShift,
 		Payload:     b[3:],
 	}, nil
 }
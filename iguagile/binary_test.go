@@ -99,3 +99,21 @@ func TestOutbound(t *testing.T) {
 		}
 	}
 }
+
+func TestDelivery(t *testing.T) {
+	in, err := NewInBoundData([]byte{1, Unreliable<<deliveryShift | 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.MessageType != 2 || in.Delivery != Unreliable {
+		t.Errorf("missmatch inbound MessageType %v Delivery %v", in.MessageType, in.Delivery)
+	}
+
+	out, err := NewOutBoundData([]byte{1, 0, UnreliableSequenced<<deliveryShift | 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.MessageType != 3 || out.Delivery != UnreliableSequenced {
+		t.Errorf("missmatch outbound MessageType %v Delivery %v", out.MessageType, out.Delivery)
+	}
+}
@@ -5,16 +5,51 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Client is a middleman between the connection and the room.
 type Client struct {
-	id     int
-	idByte []byte
-	conn   io.ReadWriteCloser
-	room   *Room
-	send   chan []byte
+	id      int
+	idByte  []byte
+	room    *Room
+	queue   *sendQueue
+	joinSeq uint64
+	latency atomic.Int64
+	log     *slog.Logger
+
+	lastReceived atomic.Int64
+	pingSent     atomic.Int64
+
+	// identity is the identity of the user, or nil if the client is anonymous.
+	identity *Identity
+
+	// resumeToken resumes the session after the connection is lost, or is nil if the session cannot be resumed.
+	resumeToken []byte
+	offline     atomic.Bool
+	current     atomic.Pointer[connection]
+
+	unregistered atomic.Bool
+	closing      chan struct{}
+	closeOnce    *sync.Once
+}
+
+// connection is the connection of the client, which is replaced when the session is resumed.
+type connection struct {
+	conn         io.ReadWriteCloser
+	disconnected chan struct{}
+	writerDone   chan struct{}
+}
+
+func newConnection(conn io.ReadWriteCloser) *connection {
+	return &connection{
+		conn:         conn,
+		disconnected: make(chan struct{}),
+		writerDone:   make(chan struct{}),
+	}
 }
 
 // NewClient is Client constructed.
@@ -28,18 +63,40 @@ func NewClient(room *Room, conn io.ReadWriteCloser) (*Client, error) {
 	binary.LittleEndian.PutUint16(idByte, uint16(id))
 
 	client := &Client{
-		id:     id,
-		idByte: idByte,
-		conn:   conn,
-		room:   room,
-		send:   make(chan []byte),
+		id:        id,
+		idByte:    idByte,
+		room:      room,
+		queue:     newSendQueue(room.sendQueue),
+		closing:   make(chan struct{}),
+		closeOnce: &sync.Once{},
+		log:       room.log.With("client_id", id),
 	}
+	client.current.Store(newConnection(conn))
+	client.queue.total = &room.dropped
+	client.lastReceived.Store(time.Now().UnixNano())
 
 	return client, nil
 }
 
-func (c *Client) read(buf []byte) (int, error) {
-	_, err := c.conn.Read(buf[:2])
+// conn returns the current connection.
+func (c *Client) conn() io.ReadWriteCloser {
+	return c.current.Load().conn
+}
+
+// messageConn is a connection that preserves message boundaries by itself.
+// Messages over a messageConn are not prefixed with their size.
+type messageConn interface {
+	readMessage(buf []byte) (int, error)
+	writeMessage(message []byte, delivery byte) error
+}
+
+// readMessage reads the message prefixed with the 2 bytes size, or the message of the messageConn.
+func readMessage(conn io.Reader, buf []byte) (int, error) {
+	if conn, ok := conn.(messageConn); ok {
+		return conn.readMessage(buf)
+	}
+
+	_, err := conn.Read(buf[:2])
 	if err != nil {
 		return 0, err
 	}
@@ -47,7 +104,7 @@ func (c *Client) read(buf []byte) (int, error) {
 	size := int(binary.LittleEndian.Uint16(buf))
 	receivedSizeSum := 0
 	for receivedSizeSum < size {
-		receivedSize, err := c.conn.Read(buf[receivedSizeSum:size])
+		receivedSize, err := conn.Read(buf[receivedSizeSum:size])
 		if err != nil {
 			return 0, err
 		}
@@ -58,45 +115,92 @@ func (c *Client) read(buf []byte) (int, error) {
 	return size, nil
 }
 
-func (c *Client) readStart() {
-	buf := make([]byte, maxMessageSize)
-	for {
-		n, err := c.read(buf)
-		if err != nil {
-			c.room.log.Println(err)
-			c.room.CloseConnection(c)
-			break
+// writeMessage writes the message prefixed with the 2 bytes size, or the message of the messageConn.
+// The delivery mode is used only by the messageConn, and other connections are always reliable.
+// The write is limited to the timeout if it is not 0.
+func writeMessage(conn io.Writer, message []byte, delivery byte, timeout time.Duration) error {
+	if conn, ok := conn.(writeDeadlineConn); ok && timeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
 		}
+	}
 
-		if err = c.room.service.Receive(c.id, buf[:n]); err != nil {
-			c.room.log.Println(err)
-			c.room.CloseConnection(c)
-			break
-		}
+	if conn, ok := conn.(messageConn); ok {
+		return conn.writeMessage(message, delivery)
 	}
-}
 
-func (c *Client) write(message []byte) error {
 	size := len(message)
 	sizeByte := make([]byte, 2, size+2)
 	binary.LittleEndian.PutUint16(sizeByte, uint16(size))
 	message = append(sizeByte, message...)
-	if _, err := c.conn.Write(message); err != nil {
+	if _, err := conn.Write(message); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Client) writeStart() {
+func (c *Client) readStart(current *connection) {
+	buf := make([]byte, maxMessageSize)
 	for {
-		if err := c.write(<-c.send); err != nil {
-			c.room.log.Println(err)
+		n, err := readMessage(current.conn, buf)
+		if err != nil {
+			c.log.Debug("disconnected", "error", err)
+			c.room.disconnect(c, current)
+			break
+		}
+
+		c.lastReceived.Store(time.Now().UnixNano())
+		if c.pong(buf[:n]) {
+			continue
+		}
+
+		// The buffer is reused for the next message, and services may keep the message.
+		message := append([]byte(nil), buf[:n]...)
+		if e := c.room.do(func() { err = c.room.receive(c.id, message) }); e != nil {
+			c.room.CloseConnection(c)
+			break
+		}
+		if err != nil {
+			c.log.Warn("failed to receive the message", "error", err)
 			c.room.CloseConnection(c)
 			break
 		}
 	}
 }
 
+// writeStart writes queued messages until the client is closed or the connection is lost.
+// Messages queued before closeAfterWrite are written before the connection is closed.
+func (c *Client) writeStart(current *connection) {
+	defer close(current.writerDone)
+	for {
+		select {
+		case <-current.disconnected:
+			return
+		default:
+		}
+
+		message, ok := c.queue.pop()
+		if !ok {
+			select {
+			case <-c.queue.notEmpty:
+				continue
+			case <-current.disconnected:
+				return
+			case <-c.closing:
+				_ = current.conn.Close()
+				return
+			}
+		}
+
+		if err := writeMessage(current.conn, message.data, message.delivery, c.room.sendQueue.WriteTimeout); err != nil {
+			c.log.Debug("failed to write the message", "error", err)
+			c.room.disconnect(c, current)
+			return
+		}
+		c.room.outbound.add(len(message.data))
+	}
+}
+
 // GetID is getter for id.
 func (c *Client) GetID() int {
 	return c.id
@@ -107,14 +211,70 @@ func (c *Client) GetIDByte() []byte {
 	return c.idByte
 }
 
-// Send is enqueue outbound messages.
+// Identity returns the identity of the user, or nil if the client is anonymous.
+func (c *Client) Identity() *Identity {
+	return c.identity
+}
+
+// Latency returns the round trip time to the client, or 0 if it is not measured.
+func (c *Client) Latency() time.Duration {
+	return time.Duration(c.latency.Load())
+}
+
+// QueueDepth returns the number of outbound messages waiting to be written.
+func (c *Client) QueueDepth() int {
+	return c.queue.len()
+}
+
+// Dropped returns the number of outbound messages dropped because the send queue was full.
+func (c *Client) Dropped() uint64 {
+	return c.queue.dropped.Load()
+}
+
+// Send is enqueue outbound messages with ReliableOrdered.
+// Messages sent after the client is closed are discarded.
+// If the send queue is full, the message is handled according to the send policy of the room.
+// Messages sent while the client is offline are buffered until it resumes the session,
+// and the session ends if the buffer is full.
 func (c *Client) Send(message []byte) {
-	c.send <- message
+	c.send(message, ReliableOrdered)
+}
+
+// send is enqueue outbound messages with the delivery mode.
+func (c *Client) send(message []byte, delivery byte) {
+	queued := queuedMessage{data: message, delivery: delivery}
+
+	select {
+	case <-c.closing:
+		return
+	default:
+	}
+
+	if c.offline.Load() {
+		if !c.queue.pushOffline(queued, c.room.resume.BufferSize) {
+			c.log.Info("offline buffer is full")
+			go c.room.CloseConnection(c)
+		}
+		return
+	}
+
+	if err := c.queue.push(queued, c.closing); err != nil {
+		c.log.Warn("failed to send the message", "error", err)
+		go c.room.CloseConnection(c)
+	}
+}
+
+// closeAfterWrite closes the connection after the message being written.
+func (c *Client) closeAfterWrite() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
 }
 
 // Close closes the connection.
 func (c *Client) Close() error {
-	return c.conn.Close()
+	c.closeAfterWrite()
+	return c.conn().Close()
 }
 
 // ClientManager manages clients.
@@ -179,20 +339,30 @@ func (m *ClientManager) Exist(clientID int) bool {
 	return ok
 }
 
-// GetAllClients returns all clients.
+// GetAllClients returns a copy of all clients.
 func (m *ClientManager) GetAllClients() map[int]*Client {
-	return m.clients
+	m.Lock()
+	defer m.Unlock()
+
+	clients := make(map[int]*Client, len(m.clients))
+	for id, client := range m.clients {
+		clients[id] = client
+	}
+	return clients
 }
 
 // Clear all clients.
 func (m *ClientManager) Clear() {
 	m.Lock()
 	m.clients = make(map[int]*Client)
+	m.count = 0
 	m.Unlock()
 }
 
 // Count clients.
 func (m *ClientManager) Count() int {
+	m.Lock()
+	defer m.Unlock()
 	return m.count
 }
 
@@ -0,0 +1,136 @@
+package iguagile
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/iguagile/iguagile-room-proto/room"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Watch event types
+const (
+	RoomCreated int32 = iota
+	ClientRegistered
+	ClientUnregistered
+	HostChanged
+	RoomClosed
+)
+
+// match checks the event passes the filter.
+func (m *WatchRoomsRequest) match(event *WatchEvent) bool {
+	if m.RoomId != 0 && m.RoomId != event.Room.RoomId {
+		return false
+	}
+	return m.ApplicationName == "" || m.ApplicationName == event.Room.ApplicationName
+}
+
+// Maximum number of events buffered for a watcher.
+// The watcher falling further behind is disconnected.
+const watchBufferSize = 256
+
+// eventBroker delivers events to watchers.
+type eventBroker struct {
+	watchers map[*watcher]struct{}
+	*sync.Mutex
+}
+
+type watcher struct {
+	filter   *WatchRoomsRequest
+	events   chan *WatchEvent
+	overflow chan struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		watchers: make(map[*watcher]struct{}),
+		Mutex:    &sync.Mutex{},
+	}
+}
+
+func (b *eventBroker) subscribe(filter *WatchRoomsRequest) *watcher {
+	w := &watcher{
+		filter:   filter,
+		events:   make(chan *WatchEvent, watchBufferSize),
+		overflow: make(chan struct{}),
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.watchers[w] = struct{}{}
+	return w
+}
+
+func (b *eventBroker) unsubscribe(w *watcher) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.watchers, w)
+}
+
+// active checks any watcher exists, so that events are not built for nobody.
+func (b *eventBroker) active() bool {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.watchers) > 0
+}
+
+// publish delivers the event to matching watchers without blocking.
+func (b *eventBroker) publish(event *WatchEvent) {
+	b.Lock()
+	defer b.Unlock()
+	for w := range b.watchers {
+		if !w.filter.match(event) {
+			continue
+		}
+
+		select {
+		case w.events <- event:
+		default:
+			delete(b.watchers, w)
+			close(w.overflow)
+		}
+	}
+}
+
+// WatchRooms streams events of rooms on the server until the client cancels it.
+func (s *RoomServer) WatchRooms(request *WatchRoomsRequest, stream RoomAdminService_WatchRoomsServer) error {
+	if err := s.authorize(request.ServerToken); err != nil {
+		return err
+	}
+
+	w := s.events.subscribe(request)
+	defer s.events.unsubscribe(w)
+
+	for {
+		select {
+		case event := <-w.events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-w.overflow:
+			return status.Error(codes.ResourceExhausted, "too many events are not received")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.closed:
+			return nil
+		}
+	}
+}
+
+// notify publishes the event of the room to the watchers.
+// It must be called on the event loop or before the event loop starts.
+func (r *Room) notify(eventType int32, clientID int, reason string) {
+	if !r.server.events.active() {
+		return
+	}
+
+	r.server.events.publish(&WatchEvent{
+		Type:       eventType,
+		Room:       proto.Clone(r.roomProto).(*pb.Room),
+		ClientId:   int32(clientID),
+		Reason:     reason,
+		TimeMillis: time.Now().UnixMilli(),
+	})
+}
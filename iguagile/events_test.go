@@ -0,0 +1,170 @@
+package iguagile
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/iguagile/iguagile-room-proto/room"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestWatchRooms(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	RegisterRoomAdminServiceServer(grpcServer, server)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	cc, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cc.Close()
+	}()
+	admin := NewRoomAdminServiceClient(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	token := server.serverProto.Token
+
+	invalid, err := admin.WatchRooms(ctx, &WatchRoomsRequest{ServerToken: []byte("invalid")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invalid.Recv(); err == nil {
+		t.Error("invalid token is accepted")
+	}
+
+	watch, err := admin.WatchRooms(ctx, &WatchRoomsRequest{ServerToken: token, ApplicationName: appName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCtx, otherCancel := context.WithCancel(ctx)
+	defer otherCancel()
+	other, err := admin.WatchRooms(otherCtx, &WatchRoomsRequest{ServerToken: token, ApplicationName: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until both watchers are subscribed.
+	for {
+		server.events.Lock()
+		n := len(server.events.watchers)
+		server.events.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	created, err := server.CreateRoom(ctx, &pb.CreateRoomRequest{
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		MaxUser:         10,
+		ServerToken:     token,
+		RoomToken:       roomToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := created.Room.RoomId
+
+	host, err := connectRoom(server, int(id), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = host.Close()
+	}()
+	guest, err := connectRoom(server, int(id), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := guest.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int32{RoomCreated, ClientRegistered, HostChanged, ClientRegistered, ClientUnregistered}
+	var hostID int32
+	for i, eventType := range expected {
+		event, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != eventType || event.Room.RoomId != id {
+			t.Fatalf("invalid event %v %v", i, event)
+		}
+
+		switch i {
+		case 0:
+			if event.ClientId != -1 {
+				t.Errorf("invalid event %v", event)
+			}
+		case 1:
+			hostID = event.ClientId
+		case 2:
+			if event.ClientId != hostID {
+				t.Errorf("invalid host %v %v", event, hostID)
+			}
+		case 4:
+			if event.ClientId == hostID || event.Room.ConnectedUser != 1 {
+				t.Errorf("invalid event %v", event)
+			}
+		}
+	}
+
+	if _, err := admin.CloseRoom(ctx, &CloseRoomRequest{ServerToken: token, RoomId: id, Reason: "maintenance"}); err != nil {
+		t.Fatal(err)
+	}
+	// The host may be unregistered after the closing message is written and before the room is closed.
+	event, err := watch.Recv()
+	if err == nil && event.Type == ClientUnregistered && event.ClientId == hostID {
+		event, err = watch.Recv()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != RoomClosed || event.Reason != "maintenance" {
+		t.Errorf("invalid event %v", event)
+	}
+
+	// Events of the other application are filtered out.
+	otherCancel()
+	if event, err := other.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("event is not filtered %v %v", event, err)
+	}
+}
+
+func TestEventBrokerOverflow(t *testing.T) {
+	broker := newEventBroker()
+	w := broker.subscribe(&WatchRoomsRequest{})
+
+	for i := 0; i <= watchBufferSize; i++ {
+		broker.publish(&WatchEvent{Room: &pb.Room{}})
+	}
+
+	select {
+	case <-w.overflow:
+	default:
+		t.Error("overflowed watcher is not disconnected")
+	}
+	if broker.active() {
+		t.Error("overflowed watcher is subscribed")
+	}
+}
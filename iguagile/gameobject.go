@@ -1,7 +1,9 @@
 package iguagile
 
 import (
+	"encoding/binary"
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -19,6 +21,45 @@ const (
 	ownerExist
 )
 
+// OwnershipPolicy decides who arbitrates ownership requests.
+type OwnershipPolicy byte
+
+// Ownership policies
+const (
+	// OwnerDecides forwards requests to the owner of the GameObject.
+	OwnerDecides OwnershipPolicy = iota
+	// HostDecides forwards requests to the host.
+	HostDecides
+	// FirstCome grants the first request for the current owner and denies the others.
+	FirstCome
+)
+
+// instantiateMessage returns the message notifies the GameObject is instantiated.
+// The payload is the 4 bytes object id, the 1 byte lifetime and the resource path.
+// The GameObject without the owner is sent by the server itself.
+func (o *GameObject) instantiateMessage() []byte {
+	payload := make([]byte, 5, 5+len(o.resourcePath))
+	binary.LittleEndian.PutUint32(payload, uint32(o.id))
+	payload[4] = o.lifetime
+	ownerID := systemSenderID
+	if o.owner != nil {
+		ownerID = o.owner.GetID()
+	}
+	return outboundMessage(ownerID, Instantiate, append(payload, o.resourcePath...))
+}
+
+// ownedBy checks the GameObject is owned by the client.
+func (o *GameObject) ownedBy(clientID int) bool {
+	return o.owner != nil && o.owner.GetID() == clientID
+}
+
+// objectMessage returns the message has the 4 bytes object id as the payload.
+func (o *GameObject) objectMessage(senderID int, messageType byte) []byte {
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, uint32(o.id))
+	return outboundMessage(senderID, messageType, payload)
+}
+
 // GameObjectManager manages GameObjects.
 type GameObjectManager struct {
 	gameObjects map[int]*GameObject
@@ -77,3 +118,281 @@ func (m *GameObjectManager) GetAllGameObjects() map[int]*GameObject {
 func (m *GameObjectManager) Clear() {
 	m.gameObjects = make(map[int]*GameObject)
 }
+
+// instantiate adds the GameObject owned by the sender and notifies all clients.
+// The payload is the 4 bytes object id, the 1 byte lifetime and the resource path.
+func (r *Room) instantiate(senderID int, payload []byte) error {
+	if len(payload) < 5 {
+		return ErrInvalidDataFormat
+	}
+
+	lifetime := payload[4]
+	if lifetime != roomExist && lifetime != ownerExist {
+		return fmt.Errorf("invalid lifetime %v", lifetime)
+	}
+
+	owner, err := r.clientManager.Get(senderID)
+	if err != nil {
+		return err
+	}
+
+	gameObject := &GameObject{
+		id:           int(binary.LittleEndian.Uint32(payload)),
+		owner:        owner,
+		lifetime:     lifetime,
+		resourcePath: append([]byte{}, payload[5:]...),
+	}
+
+	r.gameObjectManager.Lock()
+	err = r.gameObjectManager.Add(gameObject)
+	r.gameObjectManager.Unlock()
+	if err != nil {
+		r.log.Warn("failed to instantiate the game object", "error", err)
+		return nil
+	}
+
+	r.SendToAllClients(senderID, gameObject.instantiateMessage())
+	return nil
+}
+
+// destroy removes the GameObject owned by the sender and notifies all clients.
+// The payload is the 4 bytes object id.
+func (r *Room) destroy(senderID int, payload []byte) error {
+	if len(payload) < 4 {
+		return ErrInvalidDataFormat
+	}
+
+	objectID := int(binary.LittleEndian.Uint32(payload))
+
+	r.gameObjectManager.Lock()
+	gameObject, err := r.gameObjectManager.Get(objectID)
+	if err == nil && !gameObject.ownedBy(senderID) {
+		err = fmt.Errorf("client %v is not the owner of object %v", senderID, objectID)
+	}
+	if err == nil {
+		r.gameObjectManager.Remove(objectID)
+	}
+	r.gameObjectManager.Unlock()
+	if err != nil {
+		r.log.Warn("failed to destroy the game object", "error", err)
+		return nil
+	}
+
+	r.rpcBufferManager.RemoveObject(objectID)
+	r.SendToAllClients(senderID, gameObject.objectMessage(senderID, Destroy))
+	return nil
+}
+
+// releaseGameObjects destroys GameObjects exist while the owner exists,
+// and transfers the other GameObjects owned by the client to the host.
+func (r *Room) releaseGameObjects(client *Client) {
+	var messages [][]byte
+	r.gameObjectManager.Lock()
+	for _, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		if gameObject.owner != client {
+			continue
+		}
+
+		if gameObject.lifetime == ownerExist {
+			r.gameObjectManager.Remove(gameObject.id)
+			r.rpcBufferManager.RemoveObject(gameObject.id)
+			messages = append(messages, gameObject.objectMessage(client.GetID(), Destroy))
+			continue
+		}
+
+		gameObject.owner = r.host
+		if r.host != nil {
+			messages = append(messages, gameObject.objectMessage(r.host.GetID(), ChangeOwner))
+		}
+	}
+	r.gameObjectManager.Unlock()
+
+	for _, message := range messages {
+		r.SendToAllClients(client.GetID(), message)
+	}
+}
+
+// adoptGameObjects transfers GameObjects left without the owner to the host.
+func (r *Room) adoptGameObjects(host *Client) {
+	var messages [][]byte
+	r.gameObjectManager.Lock()
+	for _, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		if gameObject.owner == nil {
+			gameObject.owner = host
+			messages = append(messages, gameObject.objectMessage(host.GetID(), ChangeOwner))
+		}
+	}
+	r.gameObjectManager.Unlock()
+
+	for _, message := range messages {
+		r.SendToAllClients(host.GetID(), message)
+	}
+}
+
+// sendGameObjects sends all GameObjects to the client in order of the id.
+func (r *Room) sendGameObjects(client *Client) {
+	r.gameObjectManager.Lock()
+	gameObjects := make([]*GameObject, 0, len(r.gameObjectManager.GetAllGameObjects()))
+	for _, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		gameObjects = append(gameObjects, gameObject)
+	}
+	sort.Slice(gameObjects, func(i, j int) bool {
+		return gameObjects[i].id < gameObjects[j].id
+	})
+
+	messages := make([][]byte, len(gameObjects))
+	for i, gameObject := range gameObjects {
+		messages[i] = gameObject.instantiateMessage()
+	}
+	r.gameObjectManager.Unlock()
+
+	for _, message := range messages {
+		client.Send(message)
+	}
+}
+
+// isOwner checks the client owns the GameObject whose id is at the head of the payload.
+func (r *Room) isOwner(clientID int, payload []byte) bool {
+	if len(payload) < 4 {
+		return false
+	}
+
+	r.gameObjectManager.Lock()
+	defer r.gameObjectManager.Unlock()
+	gameObject, err := r.gameObjectManager.Get(int(binary.LittleEndian.Uint32(payload)))
+	return err == nil && gameObject.ownedBy(clientID)
+}
+
+// requestOwnership processes the ownership request according to the policy.
+// The payload is the 4 bytes object id and the 2 bytes owner id known by the requester.
+// Requests for a stale owner are denied, so the first request wins when several clients request at the same time.
+func (r *Room) requestOwnership(senderID int, payload []byte, policy OwnershipPolicy) error {
+	if len(payload) < 6 {
+		return ErrInvalidDataFormat
+	}
+
+	requester, err := r.clientManager.Get(senderID)
+	if err != nil {
+		return err
+	}
+
+	objectID := int(binary.LittleEndian.Uint32(payload))
+	ownerID := int(binary.LittleEndian.Uint16(payload[4:]))
+
+	r.gameObjectManager.Lock()
+	gameObject, err := r.gameObjectManager.Get(objectID)
+	if err != nil {
+		r.gameObjectManager.Unlock()
+		r.log.Warn("failed to request the ownership", "error", err)
+		return nil
+	}
+
+	owner := gameObject.owner
+	if owner == requester {
+		r.gameObjectManager.Unlock()
+		return nil
+	}
+
+	if !gameObject.ownedBy(ownerID) {
+		r.gameObjectManager.Unlock()
+		deniedBy := senderID
+		if owner != nil {
+			deniedBy = owner.GetID()
+		}
+		requester.Send(gameObject.objectMessage(deniedBy, DenyOwnership))
+		return nil
+	}
+
+	decider := owner
+	if policy == HostDecides {
+		decider = r.host
+	}
+
+	if policy == FirstCome || decider == requester {
+		gameObject.owner = requester
+		r.gameObjectManager.Unlock()
+		r.SendToAllClients(senderID, gameObject.objectMessage(senderID, ChangeOwner))
+		return nil
+	}
+	r.gameObjectManager.Unlock()
+
+	if decider != nil {
+		decider.Send(gameObject.objectMessage(senderID, RequestOwnership))
+	}
+	return nil
+}
+
+// answerOwnership grants or denies the ownership request.
+// The payload is the 4 bytes object id and the 2 bytes requester id.
+func (r *Room) answerOwnership(senderID int, payload []byte, policy OwnershipPolicy, grant bool) error {
+	if len(payload) < 6 {
+		return ErrInvalidDataFormat
+	}
+
+	objectID := int(binary.LittleEndian.Uint32(payload))
+	requester, err := r.clientManager.Get(int(binary.LittleEndian.Uint16(payload[4:])))
+	if err != nil {
+		r.log.Warn("failed to answer the ownership request", "error", err)
+		return nil
+	}
+
+	r.gameObjectManager.Lock()
+	gameObject, err := r.gameObjectManager.Get(objectID)
+	if err == nil {
+		decider := gameObject.owner
+		if policy == HostDecides {
+			decider = r.host
+		}
+		if policy == FirstCome || decider == nil || decider.GetID() != senderID {
+			err = fmt.Errorf("client %v cannot answer ownership requests for object %v", senderID, objectID)
+		}
+	}
+	if err == nil && grant {
+		gameObject.owner = requester
+	}
+	r.gameObjectManager.Unlock()
+	if err != nil {
+		r.log.Warn("failed to answer the ownership request", "error", err)
+		return nil
+	}
+
+	if !grant {
+		requester.Send(gameObject.objectMessage(senderID, DenyOwnership))
+		return nil
+	}
+
+	r.SendToAllClients(requester.GetID(), gameObject.objectMessage(requester.GetID(), ChangeOwner))
+	return nil
+}
+
+// transferOwnership transfers the GameObject to the client regardless of the policy.
+// Only the owner and the host can transfer. The payload is the 4 bytes object id and the 2 bytes new owner id.
+func (r *Room) transferOwnership(senderID int, payload []byte) error {
+	if len(payload) < 6 {
+		return ErrInvalidDataFormat
+	}
+
+	objectID := int(binary.LittleEndian.Uint32(payload))
+	newOwner, err := r.clientManager.Get(int(binary.LittleEndian.Uint16(payload[4:])))
+	if err != nil {
+		r.log.Warn("failed to transfer the ownership", "error", err)
+		return nil
+	}
+
+	r.gameObjectManager.Lock()
+	gameObject, err := r.gameObjectManager.Get(objectID)
+	if err == nil && !gameObject.ownedBy(senderID) && (r.host == nil || r.host.GetID() != senderID) {
+		err = fmt.Errorf("client %v cannot transfer object %v", senderID, objectID)
+	}
+	if err == nil {
+		gameObject.owner = newOwner
+	}
+	r.gameObjectManager.Unlock()
+	if err != nil {
+		r.log.Warn("failed to transfer the ownership", "error", err)
+		return nil
+	}
+
+	r.SendToAllClients(newOwner.GetID(), gameObject.objectMessage(newOwner.GetID(), ChangeOwner))
+	return nil
+}
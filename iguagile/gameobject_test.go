@@ -0,0 +1,174 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func objectPayload(objectID int, extra ...byte) []byte {
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, uint32(objectID))
+	return append(payload, extra...)
+}
+
+func TestGameObjectLifecycle(t *testing.T) {
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RoutingServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, host)
+
+	objectIDs := []int{1, 2, 3, 3}
+	requests := [][]byte{
+		append([]byte{Server, Instantiate}, objectPayload(1, ownerExist, 'a')...),
+		append([]byte{Server, Instantiate}, objectPayload(2, roomExist, 'b')...),
+		append([]byte{Server, Instantiate}, objectPayload(3, ownerExist, 'c')...),
+		append([]byte{Server, Destroy}, objectPayload(3)...),
+	}
+	for i, request := range requests {
+		if err := send(host, request); err != nil {
+			t.Fatal(err)
+		}
+
+		data := receiveOutbound(t, host)
+		if data.MessageType != request[1] || int(binary.LittleEndian.Uint32(data.Payload)) != objectIDs[i] {
+			t.Errorf("invalid data %v", data)
+		}
+	}
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The late joiner receives the current GameObjects.
+	for _, want := range [][]byte{objectPayload(1, ownerExist, 'a'), objectPayload(2, roomExist, 'b')} {
+		data := receiveOutbound(t, guest)
+		if data.MessageType != Instantiate || string(data.Payload) != string(want) {
+			t.Errorf("invalid data %v", data)
+		}
+	}
+
+	if data := receiveOutbound(t, guest); data.MessageType != ChangeHost {
+		t.Errorf("invalid data %v", data)
+	}
+
+	if data := receiveOutbound(t, host); data.MessageType != NewConnect {
+		t.Errorf("invalid data %v", data)
+	}
+
+	if err := host.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wants := map[byte]int{ChangeHost: -1, Destroy: 1, ChangeOwner: 2, ExitConnect: -1}
+	for i := 0; i < len(wants); i++ {
+		data := receiveOutbound(t, guest)
+		objectID, ok := wants[data.MessageType]
+		if !ok {
+			t.Fatalf("unexpected message type %v", data.MessageType)
+		}
+		if objectID >= 0 && int(binary.LittleEndian.Uint32(data.Payload)) != objectID {
+			t.Errorf("invalid data %v", data)
+		}
+		delete(wants, data.MessageType)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RoutingServiceFactory{OwnershipPolicy: OwnerDecides}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, host)
+
+	if err := send(host, append([]byte{Server, Instantiate}, objectPayload(1, roomExist)...)); err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, host)
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostID := receiveOutbound(t, guest).ID
+	receiveOutbound(t, guest)
+	guestID := receiveOutbound(t, host).ID
+
+	// The guest requests the ownership and the owner grants it.
+	if err := send(guest, append([]byte{Server, RequestOwnership}, objectPayload(1, hostID...)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := receiveOutbound(t, host)
+	if data.MessageType != RequestOwnership || string(data.ID) != string(guestID) {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	if err := send(host, append([]byte{Server, GrantOwnership}, objectPayload(1, guestID...)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, conn := range []io.Reader{host, guest} {
+		data := receiveOutbound(t, conn)
+		if data.MessageType != ChangeOwner || string(data.ID) != string(guestID) {
+			t.Fatalf("invalid data %v", data)
+		}
+	}
+
+	// Updates from the previous owner are dropped.
+	if err := send(host, append([]byte{OtherClients, UpdateObject}, objectPayload(1, 'a')...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(guest, append([]byte{OtherClients, UpdateObject}, objectPayload(1, 'b')...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data = receiveOutbound(t, host)
+	if data.MessageType != UpdateObject || string(data.Payload) != string(objectPayload(1, 'b')) {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	// A request for the stale owner is denied.
+	if err := send(host, append([]byte{Server, RequestOwnership}, objectPayload(1, hostID...)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data = receiveOutbound(t, host)
+	if data.MessageType != DenyOwnership || string(data.ID) != string(guestID) {
+		t.Fatalf("invalid data %v", data)
+	}
+}
+
+func TestInstantiateWithoutOwner(t *testing.T) {
+	gameObject := &GameObject{id: 1, lifetime: roomExist, resourcePath: []byte("a")}
+	message := gameObject.instantiateMessage()
+	if binary.LittleEndian.Uint16(message) != systemSenderID || message[2] != Instantiate {
+		t.Errorf("invalid message %v", message)
+	}
+}
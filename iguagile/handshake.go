@@ -0,0 +1,243 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HandshakeVersion is the version of the handshake the server speaks.
+//
+// The legacy handshake is the 4 bytes room id, the application name, the version, the password
+// and the token only if the peer is the creator, each in its own message, and is not replied.
+//
+// The versioned handshake is a single message of the 1 byte handshake version, the 4 bytes room id,
+// and the application name, the version, the password, the token, the resume token, the join ticket,
+// the display name, the properties and the auth payload, each prefixed with the 2 bytes length.
+// The properties are the 2 bytes number of properties and their keys and values, each prefixed with the 2 bytes length.
+// Fields added in later versions follow them, and the server ignores fields it does not know.
+// The server replies to the versioned handshake with a HandshakeReply.
+const HandshakeVersion = 1
+
+// Handshake status codes
+const (
+	HandshakeOK byte = iota
+	HandshakeMalformed
+	HandshakeUnsupportedVersion
+	HandshakeRoomNotFound
+	HandshakeRoomFull
+	HandshakeApplicationMismatch
+	HandshakeVersionMismatch
+	HandshakeInvalidPassword
+	HandshakeInvalidToken
+	HandshakeBanned
+	HandshakeRejected
+	HandshakeResumeFailed
+	HandshakeInvalidTicket
+	HandshakeUnauthorized
+)
+
+// Reasons of failed handshakes are replied as status codes.
+var handshakeStatus = map[string]byte{
+	failedMalformed:   HandshakeMalformed,
+	failedUnsupported: HandshakeUnsupportedVersion,
+	failedRoom:        HandshakeRoomNotFound,
+	failedCapacity:    HandshakeRoomFull,
+	failedApplication: HandshakeApplicationMismatch,
+	failedVersion:     HandshakeVersionMismatch,
+	failedPassword:    HandshakeInvalidPassword,
+	failedToken:       HandshakeInvalidToken,
+	failedBanned:      HandshakeBanned,
+	failedJoin:        HandshakeRejected,
+	failedResume:      HandshakeResumeFailed,
+	failedTicket:      HandshakeInvalidTicket,
+	failedAuth:        HandshakeUnauthorized,
+}
+
+// Client and host ids replied when they do not exist.
+const noClientID = 1<<16 - 1
+
+// HandshakeRequest is the request to join the room.
+type HandshakeRequest struct {
+	RoomID          int
+	ApplicationName string
+	Version         string
+	Password        string
+	Token           []byte
+	// ResumeToken is the token replied to the previous handshake to resume the session.
+	ResumeToken []byte
+	// Ticket is the JoinTicket admits the peer in place of the password and the token.
+	Ticket []byte
+	// DisplayName and Properties are the metadata of the user broadcast to peers.
+	DisplayName string
+	Properties  map[string]string
+	// AuthPayload is passed to the Authenticator.
+	AuthPayload []byte
+
+	// handshakeVersion is 0 for the legacy handshake.
+	handshakeVersion byte
+}
+
+// Bytes returns the versioned handshake message.
+func (r *HandshakeRequest) Bytes() []byte {
+	message := make([]byte, 5)
+	message[0] = HandshakeVersion
+	binary.LittleEndian.PutUint32(message[1:], uint32(r.RoomID))
+	fields := [][]byte{
+		[]byte(r.ApplicationName), []byte(r.Version), []byte(r.Password), r.Token, r.ResumeToken, r.Ticket,
+		[]byte(r.DisplayName), appendProperties(nil, r.Properties), r.AuthPayload,
+	}
+	for _, field := range fields {
+		message = appendField(message, field)
+	}
+	return message
+}
+
+var errUnsupportedHandshake = errors.New("unsupported handshake version")
+
+// parseHandshakeRequest parses the versioned handshake message.
+// Missing fields are empty so that fields can be added to the request.
+func parseHandshakeRequest(b []byte) (*HandshakeRequest, error) {
+	if len(b) < 5 {
+		return nil, ErrInvalidDataFormat
+	}
+	if b[0] != HandshakeVersion {
+		return nil, fmt.Errorf("%w %v", errUnsupportedHandshake, b[0])
+	}
+
+	request := &HandshakeRequest{
+		RoomID:           int(binary.LittleEndian.Uint32(b[1:5])),
+		handshakeVersion: b[0],
+	}
+
+	b = b[5:]
+	var fields [9][]byte
+	for i := range fields {
+		if len(b) == 0 {
+			break
+		}
+		if len(b) < 2 {
+			return nil, ErrInvalidDataFormat
+		}
+
+		size := int(binary.LittleEndian.Uint16(b))
+		if len(b) < 2+size {
+			return nil, ErrInvalidDataFormat
+		}
+		if size > 0 {
+			fields[i] = b[2 : 2+size]
+		}
+		b = b[2+size:]
+	}
+
+	request.ApplicationName = string(fields[0])
+	request.Version = string(fields[1])
+	request.Password = string(fields[2])
+	request.Token = fields[3]
+	request.ResumeToken = fields[4]
+	request.Ticket = fields[5]
+	request.DisplayName = string(fields[6])
+
+	properties, err := parseProperties(fields[7])
+	if err != nil {
+		return nil, err
+	}
+	request.Properties = properties
+	request.AuthPayload = fields[8]
+	return request, nil
+}
+
+// HandshakeReply is the reply to the versioned handshake.
+// ClientID is the id assigned to the peer and HostID is the id of the host of the room, or -1 if they do not exist.
+// ResumeToken resumes the session after the connection is lost, or is empty if it cannot be resumed.
+type HandshakeReply struct {
+	Version     byte
+	Status      byte
+	ClientID    int
+	HostID      int
+	Message     string
+	ResumeToken []byte
+}
+
+// Bytes returns the reply message.
+// The message starts with the 1 byte handshake version and the 1 byte status, followed by the 2 bytes client id,
+// the 2 bytes host id, and the message and the resume token, each prefixed with the 2 bytes length.
+// Fields added in later versions follow them.
+func (r *HandshakeReply) Bytes() []byte {
+	id := func(id int) uint16 {
+		if id < 0 {
+			return noClientID
+		}
+		return uint16(id)
+	}
+
+	message := []byte{r.Version, r.Status}
+	message = binary.LittleEndian.AppendUint16(message, id(r.ClientID))
+	message = binary.LittleEndian.AppendUint16(message, id(r.HostID))
+	for _, field := range [][]byte{[]byte(r.Message), r.ResumeToken} {
+		message = binary.LittleEndian.AppendUint16(message, uint16(len(field)))
+		message = append(message, field...)
+	}
+	return message
+}
+
+// NewHandshakeReply returns a HandshakeReply parsed the reply message.
+func NewHandshakeReply(b []byte) (*HandshakeReply, error) {
+	if len(b) < 8 {
+		return nil, ErrInvalidDataFormat
+	}
+
+	size := int(binary.LittleEndian.Uint16(b[6:8]))
+	if len(b) < 8+size {
+		return nil, ErrInvalidDataFormat
+	}
+
+	id := func(b []byte) int {
+		id := int(binary.LittleEndian.Uint16(b))
+		if id == noClientID {
+			return -1
+		}
+		return id
+	}
+
+	reply := &HandshakeReply{
+		Version:  b[0],
+		Status:   b[1],
+		ClientID: id(b[2:4]),
+		HostID:   id(b[4:6]),
+		Message:  string(b[8 : 8+size]),
+	}
+
+	// The resume token is missing in the reply of the server does not support resumption.
+	b = b[8+size:]
+	if len(b) >= 2 {
+		size := int(binary.LittleEndian.Uint16(b))
+		if len(b) < 2+size {
+			return nil, ErrInvalidDataFormat
+		}
+		if size > 0 {
+			reply.ResumeToken = b[2 : 2+size]
+		}
+	}
+	return reply, nil
+}
+
+// rejectHandshake replies the reason of the failure to the peer of the versioned handshake.
+// The peer failed to read or timed out is not replied.
+func rejectHandshake(conn io.Writer, request *HandshakeRequest, reason string, err error, timeout time.Duration) error {
+	status, ok := handshakeStatus[reason]
+	if !ok || (request != nil && request.handshakeVersion == 0) {
+		return nil
+	}
+
+	reply := &HandshakeReply{
+		Version:  HandshakeVersion,
+		Status:   status,
+		ClientID: -1,
+		HostID:   -1,
+		Message:  err.Error(),
+	}
+	return writeMessage(conn, reply.Bytes(), ReliableOrdered, timeout)
+}
@@ -0,0 +1,209 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandshakeRequest(t *testing.T) {
+	request := &HandshakeRequest{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		Token:           roomToken,
+		ResumeToken:     []byte{2},
+		Ticket:          []byte("ticket"),
+		DisplayName:     "name",
+		Properties:      map[string]string{"key": "value"},
+	}
+
+	parsed, err := parseHandshakeRequest(request.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.handshakeVersion = 0
+	if !reflect.DeepEqual(parsed, request) {
+		t.Errorf("invalid request %v %v", parsed, request)
+	}
+
+	// Fields added in later versions are ignored.
+	if parsed, err := parseHandshakeRequest(append(request.Bytes(), 0, 1, 2)); err != nil || parsed.ApplicationName != appName {
+		t.Errorf("invalid request %v %v", parsed, err)
+	}
+
+	// Missing fields are empty.
+	short := &HandshakeRequest{RoomID: roomID, ApplicationName: appName}
+	if parsed, err := parseHandshakeRequest(short.Bytes()[:5+2+len(appName)]); err != nil || parsed.ApplicationName != appName || parsed.Token != nil {
+		t.Errorf("invalid request %v %v", parsed, err)
+	}
+
+	if _, err := parseHandshakeRequest(request.Bytes()[:8]); err != ErrInvalidDataFormat {
+		t.Errorf("invalid error %v", err)
+	}
+
+	reply := &HandshakeReply{Version: HandshakeVersion, Status: HandshakeRoomFull, ClientID: -1, HostID: 3, Message: "full"}
+	parsedReply, err := NewHandshakeReply(reply.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsedReply, reply) {
+		t.Errorf("invalid reply %v %v", parsedReply, reply)
+	}
+}
+
+// joinVersioned connects to the server with the versioned handshake message and returns the reply.
+func joinVersioned(t *testing.T, server *RoomServer, message []byte) (net.Conn, *HandshakeReply) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(clientConn, message); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.Serve(serverConn)
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(clientConn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := NewHandshakeReply(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientConn, reply
+}
+
+func TestVersionedHandshake(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func() *HandshakeRequest {
+		return &HandshakeRequest{
+			RoomID:          roomID,
+			ApplicationName: appName,
+			Version:         appVersion,
+			Password:        password,
+			Token:           roomToken,
+		}
+	}
+
+	host, reply := joinVersioned(t, server, request().Bytes())
+	defer func() {
+		_ = host.Close()
+	}()
+	if reply.Status != HandshakeOK || reply.ClientID < 0 || reply.HostID != reply.ClientID {
+		t.Errorf("invalid reply %v", reply)
+	}
+	hostID := reply.ClientID
+
+	guestRequest := request()
+	guestRequest.Token = nil
+	guest, reply := joinVersioned(t, server, guestRequest.Bytes())
+	defer func() {
+		_ = guest.Close()
+	}()
+	if reply.Status != HandshakeOK || reply.ClientID == hostID || reply.HostID != hostID {
+		t.Errorf("invalid reply %v", reply)
+	}
+
+	wrongRoom := request()
+	wrongRoom.RoomID++
+	wrongVersion := request()
+	wrongVersion.Version = "0.0.1"
+	wrongApplication := request()
+	wrongApplication.ApplicationName = "other"
+	wrongPassword := request()
+	wrongPassword.Password = "wrong password"
+	unsupported := request().Bytes()
+	unsupported[0] = HandshakeVersion + 1
+
+	tests := []struct {
+		message []byte
+		status  byte
+	}{
+		{wrongRoom.Bytes(), HandshakeRoomNotFound},
+		{wrongVersion.Bytes(), HandshakeVersionMismatch},
+		{wrongApplication.Bytes(), HandshakeApplicationMismatch},
+		{wrongPassword.Bytes(), HandshakeInvalidPassword},
+		{unsupported, HandshakeUnsupportedVersion},
+		{[]byte{HandshakeVersion, 0, 0}, HandshakeMalformed},
+	}
+	for _, test := range tests {
+		conn, reply := joinVersioned(t, server, test.message)
+		_ = conn.Close()
+		if reply.Status != test.status || reply.ClientID != -1 || reply.Message == "" {
+			t.Errorf("invalid reply %v %v", reply, test.status)
+		}
+		if strings.Contains(reply.Message, password) || strings.Contains(reply.Message, "wrong password") {
+			t.Errorf("password is replied %v", reply)
+		}
+	}
+
+	if err := room.do(func() { room.config.MaxUser = 2 }); err != nil {
+		t.Fatal(err)
+	}
+	conn, reply := joinVersioned(t, server, guestRequest.Bytes())
+	_ = conn.Close()
+	if reply.Status != HandshakeRoomFull || !strings.Contains(reply.Message, "capacity") {
+		t.Errorf("invalid reply %v", reply)
+	}
+}
+
+func TestLegacyHandshakeUnknownRoom(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+	}()
+
+	go func() {
+		if err := server.Serve(serverConn); err == nil {
+			t.Error("joined the unknown room")
+		}
+		_ = serverConn.Close()
+	}()
+
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, roomID)
+	if err := send(clientConn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The legacy peer is disconnected without the HandshakeReply.
+	if n, err := receive(clientConn, make([]byte, maxMessageSize)); err != io.EOF {
+		t.Errorf("invalid reply %v %v", n, err)
+	}
+}
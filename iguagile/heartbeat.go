@@ -0,0 +1,79 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// HeartbeatConfig is config of pings to detect dead clients.
+// The client replies to the Ping with the Pong to the Server with the same payload.
+// Pings are disabled by default, because clients of the RelayService and older clients do not reply to them.
+type HeartbeatConfig struct {
+	// Interval is the interval of pings. Pings are disabled if it is 0.
+	Interval time.Duration
+	// Timeout disconnects the client nothing is received from while pings are enabled.
+	// It is disabled if it is 0.
+	Timeout time.Duration
+}
+
+// The payload of the ping is the 8 bytes time the ping is sent.
+const pingPayloadSize = 8
+
+// heartbeatStart pings the client and disconnects it if it does not respond until the client is closed.
+func (c *Client) heartbeatStart() {
+	config := c.room.heartbeat
+	if config.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// The offline client is not pinged until it resumes or the grace period ends.
+			if c.offline.Load() {
+				continue
+			}
+			if config.Timeout > 0 && now.Sub(time.Unix(0, c.lastReceived.Load())) > config.Timeout {
+				c.log.Info("heartbeat timeout")
+				c.room.disconnect(c, c.current.Load())
+				continue
+			}
+			c.ping(now)
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// ping sends the time the ping is sent.
+func (c *Client) ping(now time.Time) {
+	sent := now.UnixNano()
+	c.pingSent.Store(sent)
+	payload := make([]byte, pingPayloadSize)
+	binary.LittleEndian.PutUint64(payload, uint64(sent))
+	c.Send(systemMessage(Ping, payload))
+}
+
+// pong records the round trip time if the message is the reply to the last ping.
+// It returns false if the message is not a pong, or pings are disabled and the message is passed to the service.
+func (c *Client) pong(message []byte) bool {
+	if c.room.heartbeat.Interval <= 0 {
+		return false
+	}
+	if len(message) != 2+pingPayloadSize || message[0] != Server || message[1]&messageTypeMask != Pong {
+		return false
+	}
+
+	sent := int64(binary.LittleEndian.Uint64(message[2:]))
+	if sent == c.pingSent.Load() {
+		c.latency.Store(time.Now().UnixNano() - sent)
+	}
+	return true
+}
+
+// writeDeadlineConn is a connection limits the time to write.
+type writeDeadlineConn interface {
+	SetWriteDeadline(t time.Time) error
+}
@@ -0,0 +1,103 @@
+package iguagile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	server, err := NewRoomServer(RelayServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Heartbeat = HeartbeatConfig{
+		Interval: 20 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var ping *BinaryData
+	for ping == nil {
+		data := receiveOutbound(t, conn)
+		if data.MessageType == Ping {
+			ping = data
+		}
+	}
+	if len(ping.Payload) != pingPayloadSize {
+		t.Fatalf("invalid ping %v", ping)
+	}
+
+	// The pong is not relayed to the clients.
+	if err := send(conn, append([]byte{Server, Pong}, ping.Payload...)); err != nil {
+		t.Fatal(err)
+	}
+
+	clients := room.clients()
+	if len(clients) != 1 {
+		t.Fatalf("invalid clients %v", clients)
+	}
+	client := clients[0]
+	deadline := time.Now().Add(time.Second)
+	for client.Latency() <= 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if client.Latency() <= 0 {
+		t.Error("latency is not measured")
+	}
+
+	// The client stops responding.
+	time.Sleep(200 * time.Millisecond)
+	if room.clientManager.Count() != 0 {
+		t.Error("dead client is not disconnected")
+	}
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+	server, err := NewRoomServer(RelayServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// The message looks like the pong is passed to the service while pings are disabled.
+	message := append([]byte{Server, Pong}, make([]byte, pingPayloadSize)...)
+	if err := send(conn, message); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], message) {
+		t.Errorf("invalid data %v", buf[:n])
+	}
+}
@@ -0,0 +1,161 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNoHost is returned when the message is sent to the host of the room has no host.
+var ErrNoHost = errors.New("room has no host")
+
+// HostElection elects the new host when the host leaves the room.
+// If the RoomService implements HostElection, the service decides the new host.
+type HostElection interface {
+	// ElectHost returns the new host from the candidates ordered by the join time.
+	// designated is the client designated by the previous host or nil.
+	// If it returns nil for the candidates, the oldest client is elected.
+	ElectHost(candidates []*Client, designated *Client) *Client
+}
+
+// OldestClientElection elects the client joined the room first.
+type OldestClientElection struct{}
+
+// ElectHost returns the oldest client.
+func (OldestClientElection) ElectHost(candidates []*Client, _ *Client) *Client {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return candidates[0]
+}
+
+// LowestLatencyElection elects the client has the lowest round trip time.
+// Clients whose latency is not measured yet are treated as the slowest.
+type LowestLatencyElection struct{}
+
+// ElectHost returns the client has the lowest latency.
+func (LowestLatencyElection) ElectHost(candidates []*Client, _ *Client) *Client {
+	var host *Client
+	for _, client := range candidates {
+		latency := client.Latency()
+		if latency <= 0 {
+			continue
+		}
+		if host == nil || latency < host.Latency() {
+			host = client
+		}
+	}
+
+	if host == nil {
+		return OldestClientElection{}.ElectHost(candidates, nil)
+	}
+	return host
+}
+
+// DesignatedClientElection elects the client designated by the previous host.
+// The oldest client is elected if no client is designated.
+type DesignatedClientElection struct{}
+
+// ElectHost returns the designated client.
+func (DesignatedClientElection) ElectHost(candidates []*Client, designated *Client) *Client {
+	if designated != nil {
+		return designated
+	}
+
+	return OldestClientElection{}.ElectHost(candidates, nil)
+}
+
+// electHost elects the new host from the registered clients.
+// The oldest client is elected if the election elects no host or the client not in the room.
+func (r *Room) electHost() *Client {
+	candidates := r.clients()
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].joinSeq < candidates[j].joinSeq
+	})
+
+	election := r.election
+	if e, ok := r.service.(HostElection); ok {
+		election = e
+	}
+
+	host := election.ElectHost(candidates, r.designatedHost)
+	if host == nil && len(candidates) > 0 {
+		r.log.Warn("no host is elected from the clients")
+		return OldestClientElection{}.ElectHost(candidates, nil)
+	}
+	if host != nil && !r.clientManager.Exist(host.GetID()) {
+		r.log.Warn("elected host is not in the room", "client_id", host.GetID())
+		return OldestClientElection{}.ElectHost(candidates, nil)
+	}
+	return host
+}
+
+// changeHost changes the host and notifies the service.
+func (r *Room) changeHost(host *Client) {
+	r.host = host
+	r.designatedHost = nil
+	if host == nil {
+		return
+	}
+
+	r.notify(HostChanged, host.GetID(), "")
+	if err := r.service.OnChangeHost(host.GetID()); err != nil {
+		r.log.Error("failed to change the host", "client_id", host.GetID(), "error", err)
+	}
+}
+
+// requestHost forwards the request to be the host from the sender to the host.
+func (r *Room) requestHost(senderID int) {
+	if r.host == nil || r.host.GetID() == senderID {
+		return
+	}
+
+	r.host.Send(outboundMessage(senderID, RequestHost, nil))
+}
+
+// hostTarget returns the client specified by the 2 bytes client id in the payload sent by the host.
+func (r *Room) hostTarget(senderID int, payload []byte) (*Client, error) {
+	if r.host == nil || r.host.GetID() != senderID {
+		return nil, fmt.Errorf("client %v is not the host", senderID)
+	}
+
+	return r.clientManager.Get(int(binary.LittleEndian.Uint16(payload)))
+}
+
+// transferHost makes the client specified by the host the new host immediately.
+// The payload is the 2 bytes client id.
+func (r *Room) transferHost(senderID int, payload []byte) error {
+	if len(payload) < 2 {
+		return ErrInvalidDataFormat
+	}
+
+	client, err := r.hostTarget(senderID, payload)
+	if err != nil {
+		r.log.Warn("invalid host request", "client_id", senderID, "error", err)
+		return nil
+	}
+
+	if client != r.host {
+		r.changeHost(client)
+	}
+	return nil
+}
+
+// designateHost designates the client specified by the host as the next host.
+// The payload is the 2 bytes client id.
+func (r *Room) designateHost(senderID int, payload []byte) error {
+	if len(payload) < 2 {
+		return ErrInvalidDataFormat
+	}
+
+	client, err := r.hostTarget(senderID, payload)
+	if err != nil {
+		r.log.Warn("invalid host request", "client_id", senderID, "error", err)
+		return nil
+	}
+
+	r.designatedHost = client
+	return nil
+}
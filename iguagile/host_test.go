@@ -0,0 +1,176 @@
+package iguagile
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHostElection(t *testing.T) {
+	oldest, fast, designated := &Client{id: 1}, &Client{id: 2}, &Client{id: 3}
+	fast.latency.Store(int64(time.Millisecond))
+	candidates := []*Client{oldest, fast, designated}
+
+	tests := []struct {
+		election HostElection
+		want     *Client
+	}{
+		{OldestClientElection{}, oldest},
+		{LowestLatencyElection{}, fast},
+		{DesignatedClientElection{}, designated},
+	}
+	for _, test := range tests {
+		if host := test.election.ElectHost(candidates, designated); host != test.want {
+			t.Errorf("invalid host %T %v, %v", test.election, host.GetID(), test.want.GetID())
+		}
+	}
+}
+
+func TestDesignateHost(t *testing.T) {
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RoutingServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.HostElection = DesignatedClientElection{}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []net.Conn
+	var ids [][]byte
+	for i := 0; i < 3; i++ {
+		conn, err := connect(server, i == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiveOutbound(t, conn)
+		for _, c := range conns {
+			ids = append(ids, receiveOutbound(t, c).ID)
+		}
+		conns = append(conns, conn)
+	}
+
+	// The host designates the last client as the next host and leaves.
+	designated := ids[len(ids)-1]
+	if err := send(conns[0], append([]byte{Server, DesignateHost}, designated...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conns[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, conn := range conns[1:] {
+		data := receiveOutbound(t, conn)
+		if data.MessageType != ChangeHost || !bytes.Equal(data.ID, designated) {
+			t.Errorf("invalid data %v", data)
+		}
+	}
+}
+
+// hostRecorder records hosts notified to the RoutingService.
+type hostRecorder struct {
+	*RoutingService
+	hosts chan int
+}
+
+func (s *hostRecorder) OnChangeHost(clientID int) error {
+	s.hosts <- clientID
+	return s.RoutingService.OnChangeHost(clientID)
+}
+
+func TestInitialHost(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &hostRecorder{hosts: make(chan int, 1)}
+	if err := room.do(func() {
+		recorder.RoutingService = room.service.(*RoutingService)
+		room.service = recorder
+		err = room.SendToHost(0, testData)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err != ErrNoHost {
+		t.Errorf("invalid error %v", err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = host.Close()
+	}()
+
+	// The first client is notified once that it is the host.
+	data := receiveOutbound(t, host)
+	if data.MessageType != ChangeHost {
+		t.Fatalf("invalid data %v", data)
+	}
+	if hostID := <-recorder.hosts; !bytes.Equal(data.ID, []byte{byte(hostID), byte(hostID >> 8)}) {
+		t.Errorf("invalid host %v %v", hostID, data.ID)
+	}
+
+	if err := send(host, append([]byte{AllClients, UserMessage}, testData...)); err != nil {
+		t.Fatal(err)
+	}
+	if data := receiveOutbound(t, host); data.MessageType != UserMessage {
+		t.Errorf("invalid data %v", data)
+	}
+}
+
+// noElection elects no host.
+type noElection struct{}
+
+func (noElection) ElectHost(_ []*Client, _ *Client) *Client {
+	return nil
+}
+
+func TestNoElection(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.HostElection = noElection{}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, host)
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = guest.Close()
+	}()
+	receiveOutbound(t, guest)
+	guestID := receiveOutbound(t, host).ID
+
+	if err := host.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest client becomes the host.
+	if data := receiveOutbound(t, guest); data.MessageType != ChangeHost || !bytes.Equal(data.ID, guestID) {
+		t.Errorf("invalid data %v", data)
+	}
+}
@@ -0,0 +1,186 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Identity is the identity of the user behind the client.
+// UserID and Role are verified with the join ticket, and empty if the client joined without it.
+// DisplayName and Properties are supplied by the ticket or the handshake, and the ticket takes precedence.
+// It is shared by the room and must not be changed after the client joins.
+type Identity struct {
+	UserID      string
+	Role        string
+	DisplayName string
+	Properties  map[string]string
+}
+
+// Bytes returns the payload of the ClientIdentity message.
+// The payload is the user id, the role and the display name, each prefixed with the 2 bytes length,
+// followed by the 2 bytes number of properties and their keys and values, each prefixed with the 2 bytes length.
+// Properties are sorted by the key.
+func (i *Identity) Bytes() []byte {
+	var b []byte
+	for _, field := range []string{i.UserID, i.Role, i.DisplayName} {
+		b = appendField(b, []byte(field))
+	}
+	return appendProperties(b, i.Properties)
+}
+
+// NewIdentity returns an Identity parsed the payload of the ClientIdentity message.
+func NewIdentity(b []byte) (*Identity, error) {
+	identity := &Identity{}
+	for _, field := range []*string{&identity.UserID, &identity.Role, &identity.DisplayName} {
+		value, rest, err := readField(b)
+		if err != nil {
+			return nil, err
+		}
+		*field = string(value)
+		b = rest
+	}
+
+	properties, err := parseProperties(b)
+	if err != nil {
+		return nil, err
+	}
+	identity.Properties = properties
+	return identity, nil
+}
+
+// appendField appends the field prefixed with the 2 bytes length.
+func appendField(b, field []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(field)))
+	return append(b, field...)
+}
+
+// readField reads the field prefixed with the 2 bytes length, and returns the field and the rest.
+func readField(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, ErrInvalidDataFormat
+	}
+
+	size := int(binary.LittleEndian.Uint16(b))
+	if len(b) < 2+size {
+		return nil, nil, ErrInvalidDataFormat
+	}
+	return b[2 : 2+size], b[2+size:], nil
+}
+
+// appendProperties appends the 2 bytes number of properties and their keys and values sorted by the key.
+func appendProperties(b []byte, properties map[string]string) []byte {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(keys)))
+	for _, key := range keys {
+		b = appendField(b, []byte(key))
+		b = appendField(b, []byte(properties[key]))
+	}
+	return b
+}
+
+// parseProperties parses properties appended by appendProperties, or returns nil if there are no properties.
+func parseProperties(b []byte) (map[string]string, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b) < 2 {
+		return nil, ErrInvalidDataFormat
+	}
+
+	count := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	if count == 0 {
+		return nil, nil
+	}
+
+	properties := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		key, rest, err := readField(b)
+		if err != nil {
+			return nil, err
+		}
+		value, rest, err := readField(rest)
+		if err != nil {
+			return nil, err
+		}
+		properties[string(key)] = string(value)
+		b = rest
+	}
+	return properties, nil
+}
+
+// joinIdentity returns the identity of the peer joins with the request.
+// The verified identity of the join ticket takes precedence over the metadata of the request.
+// It returns nil if the peer has neither.
+func joinIdentity(verified *Identity, request *HandshakeRequest) *Identity {
+	if verified == nil && request.DisplayName == "" && len(request.Properties) == 0 {
+		return nil
+	}
+
+	identity := &Identity{DisplayName: request.DisplayName}
+	if verified != nil {
+		identity.UserID = verified.UserID
+		identity.Role = verified.Role
+		if verified.DisplayName != "" {
+			identity.DisplayName = verified.DisplayName
+		}
+	}
+
+	for _, properties := range []map[string]string{request.Properties, identityProperties(verified)} {
+		for key, value := range properties {
+			if identity.Properties == nil {
+				identity.Properties = make(map[string]string)
+			}
+			identity.Properties[key] = value
+		}
+	}
+	return identity
+}
+
+func identityProperties(identity *Identity) map[string]string {
+	if identity == nil {
+		return nil
+	}
+	return identity.Properties
+}
+
+// Identity returns the identity of the client, or nil if the client is anonymous.
+// It is called in the event loop such as from the RoomService.
+func (r *Room) Identity(clientID int) (*Identity, error) {
+	client, err := r.clientManager.Get(clientID)
+	if err != nil {
+		return nil, err
+	}
+	return client.identity, nil
+}
+
+// broadcastIdentity sends the identity of the new client to other clients,
+// and identities of other clients to the new client in order of joining.
+func (r *Room) broadcastIdentity(client *Client) {
+	clients := r.clients()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].joinSeq < clients[j].joinSeq
+	})
+
+	var message []byte
+	if client.identity != nil {
+		message = outboundMessage(client.GetID(), ClientIdentity, client.identity.Bytes())
+	}
+
+	for _, other := range clients {
+		if other == client {
+			continue
+		}
+		if other.identity != nil {
+			client.Send(outboundMessage(other.GetID(), ClientIdentity, other.identity.Bytes()))
+		}
+		if message != nil {
+			other.Send(message)
+		}
+	}
+}
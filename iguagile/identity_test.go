@@ -0,0 +1,145 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIdentity(t *testing.T) {
+	identity := &Identity{
+		UserID:      "user",
+		Role:        "player",
+		DisplayName: "name",
+		Properties:  map[string]string{"team": "red", "level": "3"},
+	}
+
+	parsed, err := NewIdentity(identity.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, identity) {
+		t.Errorf("invalid identity %v %v", parsed, identity)
+	}
+
+	if _, err := NewIdentity(identity.Bytes()[:10]); err != ErrInvalidDataFormat {
+		t.Errorf("invalid error %v", err)
+	}
+
+	// The verified identity takes precedence over the request.
+	request := &HandshakeRequest{DisplayName: "request", Properties: map[string]string{"team": "blue", "skin": "1"}}
+	joined := joinIdentity(identity, request)
+	expected := &Identity{
+		UserID:      "user",
+		Role:        "player",
+		DisplayName: "name",
+		Properties:  map[string]string{"team": "red", "level": "3", "skin": "1"},
+	}
+	if !reflect.DeepEqual(joined, expected) {
+		t.Errorf("invalid identity %v %v", joined, expected)
+	}
+
+	if joined := joinIdentity(nil, &HandshakeRequest{}); joined != nil {
+		t.Errorf("invalid identity %v", joined)
+	}
+}
+
+func TestBroadcastIdentity(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("secret")
+	server.Tickets, err = NewTicketVerifier(TicketKey{ID: "key", Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket := &JoinTicket{
+		RoomID:      roomID,
+		UserID:      "host user",
+		Role:        TicketRoleCreator,
+		DisplayName: "host",
+		ExpiresAt:   time.Now().Add(time.Minute).Unix(),
+	}
+	signed, err := ticket.SignHMAC("key", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &HandshakeRequest{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Ticket:          signed,
+		DisplayName:     "ignored",
+		Properties:      map[string]string{"team": "red"},
+	}
+	host, reply := joinVersioned(t, server, request.Bytes())
+	defer func() {
+		_ = host.Close()
+	}()
+	hostID := reply.ClientID
+	if data := receiveOutbound(t, host); data.MessageType != ChangeHost {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	request = &HandshakeRequest{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		DisplayName:     "guest",
+	}
+	guest, reply := joinVersioned(t, server, request.Bytes())
+	defer func() {
+		_ = guest.Close()
+	}()
+	guestID := reply.ClientID
+
+	hostIdentity := &Identity{UserID: "host user", Role: TicketRoleCreator, DisplayName: "host", Properties: map[string]string{"team": "red"}}
+	guestIdentity := &Identity{DisplayName: "guest"}
+
+	// The guest receives the identity of the host.
+	var data *BinaryData
+	for data == nil || data.MessageType == ChangeHost {
+		data = receiveOutbound(t, guest)
+	}
+	identity, err := NewIdentity(data.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.MessageType != ClientIdentity || int(binary.LittleEndian.Uint16(data.ID)) != hostID || !reflect.DeepEqual(identity, hostIdentity) {
+		t.Errorf("invalid identity %v %v", data, identity)
+	}
+
+	// The host receives the identity of the guest after it joins.
+	if data := receiveOutbound(t, host); data.MessageType != NewConnect {
+		t.Fatalf("invalid data %v", data)
+	}
+	data = receiveOutbound(t, host)
+	identity, err = NewIdentity(data.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.MessageType != ClientIdentity || int(binary.LittleEndian.Uint16(data.ID)) != guestID || !reflect.DeepEqual(identity, guestIdentity) {
+		t.Errorf("invalid identity %v %v", data, identity)
+	}
+
+	// Identities are readable through the room.
+	if err := room.do(func() {
+		identity, err = room.Identity(guestID)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil || !reflect.DeepEqual(identity, guestIdentity) {
+		t.Errorf("invalid identity %v %v", identity, err)
+	}
+}
@@ -0,0 +1,80 @@
+package iguagile
+
+import (
+	"time"
+)
+
+// RoomLifecycle is rules to close rooms automatically.
+// A zero duration disables the rule.
+type RoomLifecycle struct {
+	// UnclaimedTimeout closes the room the creator does not connect to.
+	UnclaimedTimeout time.Duration
+	// EmptyTimeout closes the room after the last client leaves.
+	EmptyTimeout time.Duration
+	// IdleTimeout closes the room no messages are received.
+	IdleTimeout time.Duration
+	// MaxAge closes the room regardless of the activity.
+	MaxAge time.Duration
+}
+
+const (
+	minLifecycleInterval = 10 * time.Millisecond
+	maxLifecycleInterval = time.Second
+)
+
+// checkInterval returns the interval to check the rules precisely enough for the shortest duration.
+func (l RoomLifecycle) checkInterval() time.Duration {
+	interval := maxLifecycleInterval
+	for _, d := range []time.Duration{l.UnclaimedTimeout, l.EmptyTimeout, l.IdleTimeout, l.MaxAge} {
+		if d > 0 && d/4 < interval {
+			interval = d / 4
+		}
+	}
+
+	if interval < minLifecycleInterval {
+		return minLifecycleInterval
+	}
+	return interval
+}
+
+// expired returns the reason to close the room or an empty string.
+func (l RoomLifecycle) expired(r *Room, now time.Time) string {
+	if l.MaxAge > 0 && now.Sub(r.createdAt) >= l.MaxAge {
+		return "max age exceeded"
+	}
+
+	if !r.creatorConnected.Load() {
+		if l.UnclaimedTimeout > 0 && now.Sub(r.createdAt) >= l.UnclaimedTimeout {
+			return "creator did not connect"
+		}
+		return ""
+	}
+
+	if l.EmptyTimeout > 0 && !r.emptySince.IsZero() && now.Sub(r.emptySince) >= l.EmptyTimeout {
+		return "room is empty"
+	}
+
+	if l.IdleTimeout > 0 && now.Sub(r.lastActive) >= l.IdleTimeout {
+		return "room is idle"
+	}
+
+	return ""
+}
+
+// checkLifecycle closes the room when any rule of the lifecycle expires.
+func (r *Room) checkLifecycle(lifecycle RoomLifecycle, now time.Time) {
+	reason := lifecycle.expired(r, now)
+	if reason == "" {
+		return
+	}
+
+	r.log.Info("close room", "reason", reason)
+	if err := r.close(reason); err != nil {
+		r.log.Error("failed to close the room", "error", err)
+	}
+}
+
+// touch records the room is active.
+func (r *Room) touch() {
+	r.lastActive = time.Now()
+}
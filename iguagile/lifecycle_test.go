@@ -0,0 +1,70 @@
+package iguagile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/iguagile/iguagile-room-proto/room"
+)
+
+func TestRoomLifecycle(t *testing.T) {
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RoutingServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RoomLifecycle = RoomLifecycle{
+		UnclaimedTimeout: 50 * time.Millisecond,
+		EmptyTimeout:     50 * time.Millisecond,
+	}
+
+	request := &pb.CreateRoomRequest{
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		MaxUser:         10,
+		ServerToken:     server.serverProto.Token,
+		RoomToken:       roomToken,
+	}
+
+	unclaimed, err := server.CreateRoom(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := server.CreateRoom(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := connectRoom(server, int(claimed.Room.RoomId), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := server.rooms.Load(int(unclaimed.Room.RoomId)); ok {
+		t.Error("unclaimed room is not closed")
+	}
+	if _, ok := server.rooms.Load(int(claimed.Room.RoomId)); !ok {
+		t.Error("room has a client is closed")
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := server.rooms.Load(int(claimed.Room.RoomId)); ok {
+		t.Error("empty room is not closed")
+	}
+
+	// The ids of closed rooms are freed.
+	for _, room := range []*pb.Room{unclaimed.Room, claimed.Room} {
+		if err := server.idGenerator.generator.Allocate(int(room.RoomId) & roomIDMask); err != nil {
+			t.Errorf("room id is not freed %v %v", room.RoomId, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package iguagile
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/iguagile/iguagile-room-proto/room"
+)
+
+// MemoryStore is an in-process Store for single node deployments and tests.
+type MemoryStore struct {
+	serverID    int
+	leases      map[int]time.Time
+	maxServerID int
+	// ServerIDTTL is the lease time of server ids.
+	ServerIDTTL time.Duration
+	servers     map[int32]*pb.Server
+	rooms       map[int32]*pb.Room
+	*sync.Mutex
+}
+
+// NewMemoryStore is MemoryStore constructed.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		leases:      make(map[int]time.Time),
+		maxServerID: maxServerID,
+		ServerIDTTL: time.Minute * 10,
+		servers:     make(map[int32]*pb.Server),
+		rooms:       make(map[int32]*pb.Room),
+		Mutex:       &sync.Mutex{},
+	}
+}
+
+// GenerateServerID numbers unique ServerID.
+// The id is leased for ServerIDTTL, and ids whose lease expired are reused.
+func (m *MemoryStore) GenerateServerID() (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	for i := 0; i < m.maxServerID; i++ {
+		m.serverID = m.serverID%m.maxServerID + 1
+		if expiry, ok := m.leases[m.serverID]; !ok || now.After(expiry) {
+			m.leases[m.serverID] = now.Add(m.ServerIDTTL)
+			return m.serverID << 16, nil
+		}
+	}
+
+	return 0, ErrServerIDExhausted
+}
+
+// RenewServerID extends the lease of the server id.
+// Leases are not bound to servers in the memory store, so the lease reclaimed by another server is renewed as well.
+func (m *MemoryStore) RenewServerID(serverID int) error {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	if expiry, ok := m.leases[serverID>>16]; !ok || now.After(expiry) {
+		return ErrServerIDLost
+	}
+
+	m.leases[serverID>>16] = now.Add(m.ServerIDTTL)
+	return nil
+}
+
+// ReleaseServerID deletes the lease of the server id.
+func (m *MemoryStore) ReleaseServerID(serverID int) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.leases, serverID>>16)
+	return nil
+}
+
+// RegisterServer registers the server.
+func (m *MemoryStore) RegisterServer(server *pb.Server) error {
+	server = proto.Clone(server).(*pb.Server)
+	m.Lock()
+	defer m.Unlock()
+	m.servers[server.ServerId] = server
+	return nil
+}
+
+// UnregisterServer unregisters the server.
+func (m *MemoryStore) UnregisterServer(server *pb.Server) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.servers, server.ServerId)
+	return nil
+}
+
+// DrainServer removes the server from the servers accept new rooms.
+// Rooms of the server remain registered.
+func (m *MemoryStore) DrainServer(server *pb.Server) error {
+	return m.UnregisterServer(server)
+}
+
+// RegisterRoom registers the room.
+func (m *MemoryStore) RegisterRoom(room *pb.Room) error {
+	room = proto.Clone(room).(*pb.Room)
+	m.Lock()
+	defer m.Unlock()
+	m.rooms[room.RoomId] = room
+	return nil
+}
+
+// UnregisterRoom unregisters the room.
+func (m *MemoryStore) UnregisterRoom(room *pb.Room) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.rooms, room.RoomId)
+	return nil
+}
+
+// Servers returns the registered servers accept new rooms ordered by the server id.
+func (m *MemoryStore) Servers() ([]*pb.Server, error) {
+	m.Lock()
+	servers := make([]*pb.Server, 0, len(m.servers))
+	for _, server := range m.servers {
+		servers = append(servers, proto.Clone(server).(*pb.Server))
+	}
+	m.Unlock()
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ServerId < servers[j].ServerId
+	})
+	return servers, nil
+}
+
+// Server returns the registered server accepts new rooms.
+func (m *MemoryStore) Server(serverID int) (*pb.Server, error) {
+	m.Lock()
+	defer m.Unlock()
+	server, ok := m.servers[int32(serverID)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(server).(*pb.Server), nil
+}
+
+// Room returns the registered room.
+func (m *MemoryStore) Room(roomID int) (*pb.Room, error) {
+	m.Lock()
+	defer m.Unlock()
+	room, ok := m.rooms[int32(roomID)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(room).(*pb.Room), nil
+}
+
+// Rooms returns the registered rooms ordered by the room id.
+func (m *MemoryStore) Rooms() ([]*pb.Room, error) {
+	m.Lock()
+	rooms := make([]*pb.Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, proto.Clone(room).(*pb.Room))
+	}
+	m.Unlock()
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomId < rooms[j].RoomId
+	})
+	return rooms, nil
+}
+
+// Close does nothing.
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package iguagile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons of failed handshakes.
+const (
+	failedRead        = "read"
+	failedTimeout     = "timeout"
+	failedMalformed   = "malformed"
+	failedUnsupported = "unsupported"
+	failedRoom        = "room"
+	failedCapacity    = "capacity"
+	failedApplication = "application"
+	failedVersion     = "version"
+	failedPassword    = "password"
+	failedToken       = "token"
+	failedBanned      = "banned"
+	failedJoin        = "join"
+	failedResume      = "resume"
+	failedTicket      = "ticket"
+	failedAuth        = "auth"
+)
+
+var handshakeFailureReasons = []string{
+	failedRead,
+	failedTimeout,
+	failedMalformed,
+	failedUnsupported,
+	failedRoom,
+	failedCapacity,
+	failedApplication,
+	failedVersion,
+	failedPassword,
+	failedToken,
+	failedBanned,
+	failedJoin,
+	failedResume,
+	failedTicket,
+	failedAuth,
+}
+
+// serverMetrics is metrics of the server not belonging to any room.
+// Metrics of rooms are collected from the rooms when they are scraped.
+type serverMetrics struct {
+	handshakeFailures map[string]uint64
+	*sync.Mutex
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		handshakeFailures: make(map[string]uint64),
+		Mutex:             &sync.Mutex{},
+	}
+}
+
+func (m *serverMetrics) handshakeFailed(reason string) {
+	m.Lock()
+	defer m.Unlock()
+	m.handshakeFailures[reason]++
+}
+
+// metricSample is a value of the metric with formatted labels.
+type metricSample struct {
+	labels string
+	value  uint64
+}
+
+// labels formats label pairs in the Prometheus text format.
+func labels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	values := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		values = append(values, fmt.Sprintf(`%v="%v"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+// writeMetric writes the metric in the Prometheus text format.
+func writeMetric(buf *bytes.Buffer, name, kind, help string, samples []metricSample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].labels < samples[j].labels
+	})
+
+	fmt.Fprintf(buf, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+	for _, sample := range samples {
+		fmt.Fprintf(buf, "%v%v %v\n", name, sample.labels, sample.value)
+	}
+}
+
+// writeMetrics writes metrics of the server and the rooms in the Prometheus text format.
+func (s *RoomServer) writeMetrics(buf *bytes.Buffer) {
+	rooms := make(map[string]uint64)
+	clients := make(map[string]uint64)
+	var receivedMessages, receivedBytes, sentMessages, sentBytes, dropped, receiveErrors []metricSample
+	s.rooms.Range(func(_, value interface{}) bool {
+		room, ok := value.(*Room)
+		if !ok {
+			return true
+		}
+
+		// The application name and the version are not changed after the room is created.
+		application := labels("application", room.config.ApplicationName, "version", room.config.Version)
+		rooms[application]++
+		clients[application] += uint64(room.clientManager.Count())
+
+		l := labels("room_id", strconv.Itoa(room.config.RoomID), "application", room.config.ApplicationName)
+		receivedMessages = append(receivedMessages, metricSample{l, room.inbound.messages.Load()})
+		receivedBytes = append(receivedBytes, metricSample{l, room.inbound.bytes.Load()})
+		sentMessages = append(sentMessages, metricSample{l, room.outbound.messages.Load()})
+		sentBytes = append(sentBytes, metricSample{l, room.outbound.bytes.Load()})
+		dropped = append(dropped, metricSample{l, room.dropped.Load()})
+		receiveErrors = append(receiveErrors, metricSample{l, room.receiveErrors.Load()})
+		return true
+	})
+
+	samples := func(values map[string]uint64) []metricSample {
+		result := make([]metricSample, 0, len(values))
+		for l, value := range values {
+			result = append(result, metricSample{l, value})
+		}
+		return result
+	}
+
+	s.metrics.Lock()
+	failures := make([]metricSample, 0, len(handshakeFailureReasons))
+	for _, reason := range handshakeFailureReasons {
+		failures = append(failures, metricSample{labels("reason", reason), s.metrics.handshakeFailures[reason]})
+	}
+	s.metrics.Unlock()
+
+	writeMetric(buf, "iguagile_rooms", "gauge", "Number of rooms.", samples(rooms))
+	writeMetric(buf, "iguagile_clients", "gauge", "Number of connected clients.", samples(clients))
+	writeMetric(buf, "iguagile_handshake_failures_total", "counter", "Number of failed handshakes.", failures)
+	writeMetric(buf, "iguagile_room_received_messages_total", "counter", "Number of messages received from clients.", receivedMessages)
+	writeMetric(buf, "iguagile_room_received_bytes_total", "counter", "Bytes received from clients.", receivedBytes)
+	writeMetric(buf, "iguagile_room_sent_messages_total", "counter", "Number of messages sent to clients.", sentMessages)
+	writeMetric(buf, "iguagile_room_sent_bytes_total", "counter", "Bytes sent to clients.", sentBytes)
+	writeMetric(buf, "iguagile_room_send_queue_dropped_total", "counter", "Number of outbound messages dropped by send queues.", dropped)
+	writeMetric(buf, "iguagile_room_receive_errors_total", "counter", "Number of errors returned by the room service on receive.", receiveErrors)
+}
+
+// MetricsHandler returns the http handler exposes metrics in the Prometheus text format.
+func (s *RoomServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		buf := &bytes.Buffer{}
+		s.writeMetrics(buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			s.log().Debug("failed to write metrics", "error", err)
+		}
+	})
+}
+
+// ServeMetrics serves metrics on /metrics until the server is shut down.
+func (s *RoomServer) ServeMetrics(listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.closed:
+			_ = server.Close()
+		case <-done:
+		}
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return ErrServerClosed
+}
@@ -0,0 +1,110 @@
+package iguagile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = host.Close()
+	}()
+	receiveOutbound(t, host)
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, guest)
+	receiveOutbound(t, host)
+
+	if err := send(host, append([]byte{OtherClients, UserMessage}, testData...)); err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, guest)
+
+	// The invalid message makes the service return an error, and the guest is disconnected.
+	if err := send(guest, []byte{OtherClients}); err != nil {
+		t.Fatal(err)
+	}
+	if exit := receiveOutbound(t, host); exit.MessageType != ExitConnect {
+		t.Errorf("invalid data %v", exit)
+	}
+
+	if _, err := connectRoom(server, roomID+1, false); err == nil {
+		t.Error("connected to the room does not exist")
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.ServeMetrics(listener)
+	}()
+
+	response, err := http.Get(fmt.Sprintf("http://%v/metrics", listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(body)
+
+	application := `application="iguana online",version="0.0.0 beta"`
+	room := fmt.Sprintf(`room_id="%v",application="iguana online"`, roomID)
+	expected := []string{
+		"# TYPE iguagile_rooms gauge",
+		"iguagile_rooms{" + application + "} 1",
+		"iguagile_clients{" + application + "} 1",
+		`iguagile_handshake_failures_total{reason="room"} 1`,
+		`iguagile_handshake_failures_total{reason="password"} 0`,
+		"iguagile_room_received_messages_total{" + room + "} 2",
+		"iguagile_room_receive_errors_total{" + room + "} 1",
+		"iguagile_room_send_queue_dropped_total{" + room + "} 0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metric is not found %v\n%v", line, metrics)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := http.Get(fmt.Sprintf("http://%v/metrics", listener.Addr())); err == nil {
+		t.Error("metrics are served after shutdown")
+	}
+}
+
+func TestLabels(t *testing.T) {
+	if l := labels("application", "a\"b\\c\nd", "version", "1"); l != `{application="a\"b\\c\nd",version="1"}` {
+		t.Errorf("invalid labels %v", l)
+	}
+}
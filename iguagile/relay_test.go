@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 const (
@@ -26,13 +27,19 @@ var (
 	testData   = []byte("test data")
 )
 
+// setupServer sets up the relay server on the redis of REDIS_HOST, or the MemoryStore if it is not set.
 func setupServer() error {
 	factory := &RelayServiceFactory{}
-	store, err := NewRedis(os.Getenv("REDIS_HOST"))
-	if err != nil {
-		return err
+	var store Store = NewMemoryStore()
+	if host := os.Getenv("REDIS_HOST"); host != "" {
+		redis, err := NewRedis(host)
+		if err != nil {
+			return err
+		}
+		store = redis
 	}
 
+	var err error
 	roomServer, err = NewRoomServer(factory, store, address)
 	if err != nil {
 		return err
@@ -42,6 +49,10 @@ func setupServer() error {
 }
 
 func createRoom() (*Room, error) {
+	return createRoomOn(roomServer)
+}
+
+func createRoomOn(server *RoomServer) (*Room, error) {
 	conf := &RoomConfig{
 		RoomID:          roomID,
 		ApplicationName: appName,
@@ -51,17 +62,18 @@ func createRoom() (*Room, error) {
 		Token:           roomToken,
 	}
 
-	room, err := newRoom(roomServer, conf)
+	room, err := newRoom(server, conf)
 	if err != nil {
 		return nil, err
 	}
 
-	service, err := roomServer.factory.Create(room)
+	service, err := server.factory.Create(room)
 	if err != nil {
 		return nil, err
 	}
 	room.service = service
-	roomServer.rooms.Store(roomID, room)
+	server.rooms.Store(roomID, room)
+	go room.run(server.RoomLifecycle)
 
 	return room, nil
 }
@@ -146,3 +158,77 @@ func TestRelayService(t *testing.T) {
 
 	t.Logf("%v, %v", buf[:n], testData)
 }
+
+func TestRelayServiceQueuedMessages(t *testing.T) {
+	server, err := NewRoomServer(RelayServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages to the pipe are queued until the receiver reads them.
+	receiver, serverConn := net.Pipe()
+	defer func() {
+		_ = receiver.Close()
+	}()
+	go func() {
+		_ = handshake(receiver, roomID, true)
+	}()
+	if err := server.Serve(serverConn); err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = sender.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(io.Discard, sender)
+	}()
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		if err := send(sender, append([]byte{byte(i)}, testData...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first message is being written, and the others are queued.
+	deadline := time.Now().Add(time.Second)
+	for {
+		var depth int
+		if err := room.do(func() {
+			for _, client := range room.clients() {
+				depth += client.QueueDepth()
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if depth >= count-1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("messages are not queued %v", depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for i := 0; i < count; i++ {
+		n, err := receive(receiver, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := append([]byte{byte(i)}, testData...); !bytes.Equal(buf[:n], want) {
+			t.Errorf("invalid data %v, %v", buf[:n], want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package iguagile
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"time"
+)
+
+// ResumeConfig is config of resuming sessions of clients disconnected transiently.
+// Only clients joined with the versioned handshake receive the resume token.
+type ResumeConfig struct {
+	// GracePeriod is the time the disconnected client can resume the session in.
+	// The client keeps the client id, owned objects and the host status until then.
+	// Resumption is disabled if it is 0.
+	GracePeriod time.Duration
+	// BufferSize is the maximum number of messages buffered for the disconnected client.
+	// The session ends when it is exceeded.
+	BufferSize int
+}
+
+const resumeTokenSize = 16
+
+var errResumeFailed = errors.New("session cannot be resumed")
+
+func newResumeToken() ([]byte, error) {
+	token := make([]byte, resumeTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// disconnect handles the lost connection of the client.
+// The client has the resume token is suspended for the grace period instead of being unregistered.
+// It must not be called from the event loop.
+func (r *Room) disconnect(client *Client, current *connection) {
+	suspended := false
+	if client.resumeToken != nil {
+		if e := r.do(func() { suspended = r.suspend(client, current) }); e != nil {
+			suspended = false
+		}
+	}
+
+	if !suspended {
+		r.CloseConnection(client)
+	}
+}
+
+// suspend keeps the client registered while it is offline, and ends the session after the grace period.
+// It returns false if the client is closing and should be unregistered.
+func (r *Room) suspend(client *Client, current *connection) bool {
+	if client.unregistered.Load() {
+		return false
+	}
+
+	select {
+	case <-client.closing:
+		return false
+	default:
+	}
+
+	// Both the reader and the writer of the connection fail.
+	if client.current.Load() != current || client.offline.Load() {
+		return true
+	}
+
+	client.offline.Store(true)
+	close(current.disconnected)
+	_ = current.conn.Close()
+	client.log.Info("suspended")
+
+	time.AfterFunc(r.resume.GracePeriod, func() {
+		_ = r.do(func() { r.expire(client, current) })
+	})
+	return true
+}
+
+// expire ends the session of the client did not resume on the connection.
+func (r *Room) expire(client *Client, current *connection) {
+	if !client.offline.Load() || client.current.Load() != current || client.unregistered.Swap(true) {
+		return
+	}
+
+	client.log.Info("session expired")
+	if err := r.unregister(client); err != nil {
+		client.log.Error("failed to unregister the client", "error", err)
+	}
+	_ = client.Close()
+}
+
+// resumeSession replaces the connection of the offline client has the token.
+// The HandshakeReply is written before messages buffered while the client is offline.
+// It must not be called from the event loop.
+func (r *Room) resumeSession(conn io.ReadWriteCloser, token []byte) error {
+	var client *Client
+	var lost *connection
+	if e := r.do(func() {
+		client = r.offlineClient(token)
+		if client != nil {
+			lost = client.current.Load()
+		}
+	}); e != nil {
+		return e
+	}
+	if client == nil {
+		return errResumeFailed
+	}
+
+	// The writer of the lost connection must not take messages for the new connection.
+	<-lost.writerDone
+
+	var err error
+	if e := r.do(func() { err = r.reconnect(client, lost, conn) }); e != nil {
+		return e
+	}
+	return err
+}
+
+// offlineClient returns the offline client has the token, or nil.
+func (r *Room) offlineClient(token []byte) *Client {
+	for _, client := range r.clients() {
+		if client.offline.Load() && subtle.ConstantTimeCompare(client.resumeToken, token) == 1 {
+			return client
+		}
+	}
+	return nil
+}
+
+// reconnect starts the reader and the writer of the client on the new connection.
+func (r *Room) reconnect(client *Client, lost *connection, conn io.ReadWriteCloser) error {
+	if client.unregistered.Load() || !client.offline.Load() || client.current.Load() != lost {
+		return errResumeFailed
+	}
+
+	current := newConnection(conn)
+	client.current.Store(current)
+	client.lastReceived.Store(time.Now().UnixNano())
+	client.offline.Store(false)
+	r.touch()
+
+	reply := &HandshakeReply{
+		Version:     HandshakeVersion,
+		Status:      HandshakeOK,
+		ClientID:    client.GetID(),
+		HostID:      -1,
+		ResumeToken: client.resumeToken,
+	}
+	if r.host != nil {
+		reply.HostID = r.host.GetID()
+	}
+	client.queue.pushFront(reply.Bytes())
+
+	go client.writeStart(current)
+	go client.readStart(current)
+	client.log.Info("resumed")
+	return nil
+}
@@ -0,0 +1,158 @@
+package iguagile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// waitOffline waits for the only client of the room to be offline, and returns it.
+func waitOffline(t *testing.T, room *Room) *Client {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if clients := room.clients(); len(clients) == 1 && clients[0].offline.Load() {
+			return clients[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("client is not offline")
+	return nil
+}
+
+func TestResume(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &HandshakeRequest{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		Token:           roomToken,
+	}
+	host, reply := joinVersioned(t, server, request.Bytes())
+	if reply.Status != HandshakeOK || len(reply.ResumeToken) != resumeTokenSize {
+		t.Fatalf("invalid reply %v", reply)
+	}
+	hostID := reply.ClientID
+	token := reply.ResumeToken
+	if data := receiveOutbound(t, host); data.MessageType != ChangeHost {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	// The host loses the connection, and messages to it are buffered.
+	_ = host.Close()
+	waitOffline(t, room)
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = guest.Close()
+	}()
+
+	if data := receiveOutbound(t, guest); data.MessageType != ChangeHost {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	for i := byte(0); i < 3; i++ {
+		if err := send(guest, []byte{Host, UserMessage, i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The wrong token does not resume the session.
+	wrong := &HandshakeRequest{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		ResumeToken:     bytes.Repeat([]byte{1}, resumeTokenSize),
+	}
+	conn, reply := joinVersioned(t, server, wrong.Bytes())
+	_ = conn.Close()
+	if reply.Status != HandshakeResumeFailed || reply.ClientID != -1 {
+		t.Errorf("invalid reply %v", reply)
+	}
+
+	request.Token = nil
+	request.ResumeToken = token
+	host, reply = joinVersioned(t, server, request.Bytes())
+	defer func() {
+		_ = host.Close()
+	}()
+	if reply.Status != HandshakeOK || reply.ClientID != hostID || reply.HostID != hostID || !bytes.Equal(reply.ResumeToken, token) {
+		t.Fatalf("invalid reply %v", reply)
+	}
+
+	// Messages sent while the host is offline are replayed in order.
+	if data := receiveOutbound(t, host); data.MessageType != NewConnect {
+		t.Fatalf("invalid data %v", data)
+	}
+	for i := byte(0); i < 3; i++ {
+		data := receiveOutbound(t, host)
+		if data.MessageType != UserMessage || !bytes.Equal(data.Payload, []byte{i}) {
+			t.Errorf("invalid data %v %v", data, i)
+		}
+	}
+
+	// The resumed connection is used for new messages.
+	if err := send(guest, []byte{Host, UserMessage, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if data := receiveOutbound(t, host); !bytes.Equal(data.Payload, []byte{3}) {
+		t.Errorf("invalid data %v", data)
+	}
+	if room.clientManager.Count() != 2 {
+		t.Errorf("invalid clients %v", room.clientManager.Count())
+	}
+}
+
+func TestResumeExpired(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Resume.GracePeriod = 50 * time.Millisecond
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &HandshakeRequest{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		Token:           roomToken,
+	}
+	host, reply := joinVersioned(t, server, request.Bytes())
+	_ = host.Close()
+	client := waitOffline(t, room)
+
+	deadline := time.Now().Add(time.Second)
+	for room.clientManager.Count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if room.clientManager.Count() != 0 || !client.unregistered.Load() {
+		t.Fatal("expired client is not unregistered")
+	}
+
+	request.Token = nil
+	request.ResumeToken = reply.ResumeToken
+	conn, reply := joinVersioned(t, server, request.Bytes())
+	_ = conn.Close()
+	if reply.Status != HandshakeResumeFailed {
+		t.Errorf("invalid reply %v", reply)
+	}
+}
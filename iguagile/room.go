@@ -1,28 +1,50 @@
 package iguagile
 
 import (
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math"
-	"os"
+	"sync/atomic"
+	"time"
 
 	pb "github.com/iguagile/iguagile-room-proto/room"
 )
 
 // Room maintains the set of active clients and broadcasts messages to the
 // clients.
+// The room state is owned by the event loop of the room, and changed only by events processed in it.
 type Room struct {
-	clientManager    *ClientManager
-	rpcBufferManager *RPCBufferManager
-	generator        *IDGenerator
-	log              *log.Logger
-	host             *Client
-	config           *RoomConfig
-	creatorConnected bool
-	roomProto        *pb.Room
-	store            Store
-	server           *RoomServer
-	service          RoomService
+	clientManager     *ClientManager
+	rpcBufferManager  *RPCBufferManager
+	gameObjectManager *GameObjectManager
+	generator         *IDGenerator
+	log               *slog.Logger
+	host              *Client
+	designatedHost    *Client
+	election          HostElection
+	sendQueue         SendQueueConfig
+	heartbeat         HeartbeatConfig
+	resume            ResumeConfig
+	joinCount         uint64
+	config            *RoomConfig
+	creatorConnected  atomic.Bool
+	roomProto         *pb.Room
+	store             Store
+	server            *RoomServer
+	service           RoomService
+	createdAt         time.Time
+	lastActive        time.Time
+	emptySince        time.Time
+	inbound           traffic
+	outbound          traffic
+	dropped           atomic.Uint64
+	receiveErrors     atomic.Uint64
+	banned            map[string]bool
+	bannedUsers       map[string]bool
+	events            chan roomEvent
+	closed            chan struct{}
 }
 
 // RoomConfig is room config.
@@ -42,126 +64,423 @@ func newRoom(server *RoomServer, config *RoomConfig) (*Room, error) {
 		return nil, err
 	}
 
-	return &Room{
-		clientManager:    NewClientManager(),
-		rpcBufferManager: NewRPCBufferManager(),
-		generator:        gen,
-		log:              log.New(os.Stdout, "iguagile-engine ", log.Lshortfile),
-		config:           config,
-		store:            server.store,
-		roomProto:        &pb.Room{},
-		server:           server,
-	}, nil
+	room := &Room{
+		clientManager:     NewClientManager(),
+		rpcBufferManager:  NewRPCBufferManager(),
+		gameObjectManager: NewGameObjectManager(),
+		generator:         gen,
+		log:               server.log().With("room_id", config.RoomID, "application", config.ApplicationName),
+		config:            config,
+		store:             server.store,
+		roomProto: &pb.Room{
+			RoomId:          int32(config.RoomID),
+			RequirePassword: config.Password != "",
+			MaxUser:         int32(config.MaxUser),
+			ConnectedUser:   0,
+			Server:          server.serverProto,
+			ApplicationName: config.ApplicationName,
+			Version:         config.Version,
+			Information:     config.Info,
+		},
+		server:      server,
+		election:    server.HostElection,
+		sendQueue:   server.SendQueue,
+		heartbeat:   server.Heartbeat,
+		resume:      server.Resume,
+		createdAt:   time.Now(),
+		banned:      make(map[string]bool),
+		bannedUsers: make(map[string]bool),
+		events:      make(chan roomEvent),
+		closed:      make(chan struct{}),
+	}
+	room.touch()
+	return room, nil
+}
+
+// roomEvent is processed by the event loop of the room.
+type roomEvent func()
+
+var errRoomClosed = errors.New("room closed")
+
+var (
+	errBannedHost = errors.New("banned host")
+	errBannedUser = errors.New("banned user")
+	errRoomFull   = errors.New("connected clients exceed room capacity")
+)
+
+// run is the event loop of the room.
+// It processes events and checks the lifecycle until the room is closed.
+func (r *Room) run(lifecycle RoomLifecycle) {
+	ticker := time.NewTicker(lifecycle.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-r.events:
+			event()
+		case now := <-ticker.C:
+			r.checkLifecycle(lifecycle, now)
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+// do processes the event on the event loop and waits for it to finish.
+// It returns errRoomClosed if the room is closed before the event is processed.
+// It must not be called from the event loop.
+func (r *Room) do(event roomEvent) error {
+	done := make(chan struct{})
+	select {
+	case r.events <- func() {
+		defer close(done)
+		event()
+	}:
+	case <-r.closed:
+		return errRoomClosed
+	}
+
+	<-done
+	return nil
+}
+
+// configSnapshot returns a copy of the config, which is updated on the event loop.
+func (r *Room) configSnapshot() (RoomConfig, error) {
+	var config RoomConfig
+	err := r.do(func() { config = *r.config })
+	return config, err
 }
 
-func (r *Room) serve(conn io.ReadWriteCloser) error {
+func (r *Room) serve(conn io.ReadWriteCloser, admitted *admission, handshakeVersion byte) error {
+	var err error
+	if e := r.do(func() { err = r.join(conn, admitted, handshakeVersion) }); e != nil {
+		return e
+	}
+	return err
+}
+
+// join creates the client for the connection and registers it.
+// The room is registered to the store when the creator joins.
+// The client of the versioned handshake receives the HandshakeReply before any other message.
+func (r *Room) join(conn io.ReadWriteCloser, admitted *admission, handshakeVersion byte) error {
+	creator := admitted.creator
+	if identity := admitted.identity; identity != nil && r.bannedUsers[identity.UserID] {
+		return fmt.Errorf("%w %v", errBannedUser, identity.UserID)
+	}
+	if host := remoteHost(conn); r.banned[host] {
+		return fmt.Errorf("%w %v", errBannedHost, host)
+	}
+
+	if r.clientManager.Count() >= r.config.MaxUser {
+		return fmt.Errorf("%w %v %v", errRoomFull, r.config.MaxUser, r.clientManager.Count())
+	}
+
+	var token []byte
+	if handshakeVersion > 0 && r.resume.GracePeriod > 0 {
+		var err error
+		if token, err = newResumeToken(); err != nil {
+			return err
+		}
+	}
+
 	client, err := NewClient(r, conn)
 	if err != nil {
 		return err
 	}
+	client.resumeToken = token
+	client.identity = admitted.identity
+
+	if handshakeVersion > 0 {
+		hostID := -1
+		if r.host != nil {
+			hostID = r.host.GetID()
+		} else if r.clientManager.Count() == 0 {
+			hostID = client.GetID()
+		}
+
+		reply := &HandshakeReply{Version: HandshakeVersion, Status: HandshakeOK, ClientID: client.GetID(), HostID: hostID, ResumeToken: token}
+		client.Send(reply.Bytes())
+	}
+
+	if err := r.register(client); err != nil {
+		return err
+	}
+
+	// The room is registered with the client only after it is registered,
+	// and the room ticker retries the registration if the store fails.
+	if creator {
+		r.creatorConnected.Store(true)
+	}
+	r.roomProto.ConnectedUser = int32(r.clientManager.Count())
+	r.registerRoom()
+	return nil
+}
+
+// registerRoom registers the room to the store.
+// Rooms are not registered after the server lost the lease of the server id,
+// so that they do not overwrite rooms of the server reusing the id.
+func (r *Room) registerRoom() {
+	if r.server.idLost.Load() {
+		return
+	}
 
-	r.roomProto.ConnectedUser = int32(r.clientManager.count + 1)
 	if err := r.store.RegisterRoom(r.roomProto); err != nil {
-		r.log.Println(err)
+		r.log.Error("failed to register the room", "error", err)
 	}
-	return r.register(client)
 }
 
 const (
 	// Maximum message size allowed from peer.
 	maxMessageSize = math.MaxUint16
+
+	// The room id generated by the server is in the lower bits, the server id is in the upper bits.
+	roomIDMask = 1<<16 - 1
 )
 
 // register requests from the clients.
 func (r *Room) register(client *Client) error {
+	r.joinCount++
+	client.joinSeq = r.joinCount
+	r.emptySince = time.Time{}
+	r.touch()
 	if err := r.clientManager.Add(client); err != nil {
 		return err
 	}
 
-	go client.writeStart()
+	current := client.current.Load()
+	go client.writeStart(current)
+	go client.heartbeatStart()
+	r.notify(ClientRegistered, client.GetID(), "")
 	if r.clientManager.Count() == 1 {
-		r.host = client
+		r.changeHost(client)
+		r.adoptGameObjects(client)
 	}
 
-	go client.readStart()
+	r.sendGameObjects(client)
+	r.rpcBufferManager.SendRPCBuffer(client)
+	go client.readStart(current)
 
-	return r.service.OnRegisterClient(client.id)
+	if service, ok := r.service.(IdentityService); ok && client.identity != nil {
+		if err := service.OnIdentifyClient(client.id, client.identity); err != nil {
+			return err
+		}
+	}
+	if err := r.service.OnRegisterClient(client.id); err != nil {
+		return err
+	}
+
+	r.broadcastIdentity(client)
+	return nil
 }
 
 // unregister requests from clients.
 func (r *Room) unregister(client *Client) error {
 	if err := r.generator.Free(client.GetID()); err != nil {
-		r.log.Println(err)
+		r.log.Error("failed to free the client id", "client_id", client.GetID(), "error", err)
 	}
 
 	r.clientManager.Remove(client.GetID())
+	if r.clientManager.Count() == 0 {
+		r.emptySince = time.Now()
+	}
+
+	r.roomProto.ConnectedUser = int32(r.clientManager.Count())
+	if r.creatorConnected.Load() {
+		r.registerRoom()
+	}
+	r.notify(ClientUnregistered, client.GetID(), "")
+
+	if client == r.designatedHost {
+		r.designatedHost = nil
+	}
 	if client == r.host {
-		c, err := r.clientManager.First()
-		if err != nil {
-			return err
-		}
-		r.host = c
+		r.changeHost(r.electHost())
 	}
 
+	r.releaseGameObjects(client)
+
 	return r.service.OnUnregisterClient(client.id)
 }
 
+// receive passes the message from the client to the service.
+func (r *Room) receive(senderID int, message []byte) error {
+	r.touch()
+	r.inbound.add(len(message))
+	if err := r.service.Receive(senderID, message); err != nil {
+		r.receiveErrors.Add(1)
+		return err
+	}
+	return nil
+}
+
 // SendToHost sends outbound message to the host.
-func (r *Room) SendToHost(senderID int, message []byte) {
-	r.host.Send(message)
+// It returns ErrNoHost if the room has no host.
+func (r *Room) SendToHost(senderID int, message []byte) error {
+	return r.sendToHost(senderID, message, ReliableOrdered)
 }
 
 // SendToClient sends outbound message to the client.
 func (r *Room) SendToClient(targetID, senderID int, message []byte) {
+	r.sendToClient(targetID, senderID, message, ReliableOrdered)
+}
+
+// SendToAllClients sends outbound message to all registered clients.
+func (r *Room) SendToAllClients(senderID int, message []byte) {
+	r.sendToAllClients(senderID, message, ReliableOrdered)
+}
+
+// SendToOtherClients sends outbound message to other registered clients.
+func (r *Room) SendToOtherClients(senderID int, message []byte) {
+	r.sendToOtherClients(senderID, message, ReliableOrdered)
+}
+
+func (r *Room) sendToHost(senderID int, message []byte, delivery byte) error {
+	if r.host == nil {
+		return ErrNoHost
+	}
+
+	r.host.send(message, delivery)
+	return nil
+}
+
+func (r *Room) sendToClient(targetID, senderID int, message []byte, delivery byte) {
 	client, err := r.clientManager.Get(targetID)
 	if err != nil {
-		r.log.Println(err)
+		r.log.Warn("target client is not in the room", "client_id", senderID, "error", err)
 		return
 	}
 
-	client.Send(message)
+	client.send(message, delivery)
 }
 
-// SendToAllClients sends outbound message to all registered clients.
-func (r *Room) SendToAllClients(senderID int, message []byte) {
-	r.clientManager.Lock()
-	defer r.clientManager.Unlock()
-	for _, client := range r.clientManager.GetAllClients() {
-		client.Send(message)
+func (r *Room) sendToAllClients(senderID int, message []byte, delivery byte) {
+	for _, client := range r.clients() {
+		client.send(message, delivery)
 	}
 }
 
-// SendToOtherClients sends outbound message to other registered clients.
-func (r *Room) SendToOtherClients(senderID int, message []byte) {
-	r.clientManager.Lock()
-	defer r.clientManager.Unlock()
-	for id, client := range r.clientManager.GetAllClients() {
-		if id != senderID {
-			client.Send(message)
+func (r *Room) sendToOtherClients(senderID int, message []byte, delivery byte) {
+	for _, client := range r.clients() {
+		if client.id != senderID {
+			client.send(message, delivery)
 		}
 	}
 }
 
+// clients returns a snapshot of registered clients.
+// Messages are sent without holding the lock so that a slow client does not block the others.
+func (r *Room) clients() []*Client {
+	clients := make([]*Client, 0, r.clientManager.Count())
+	for _, client := range r.clientManager.GetAllClients() {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
 // CloseConnection closes the connection and unregisters the client.
+// The client is unregistered only once even if both the reader and the writer fail.
+// It must not be called from the event loop, which runs the RoomService callbacks.
+// The callbacks use CloseConnectionFromService instead.
 func (r *Room) CloseConnection(client *Client) {
-	if err := r.unregister(client); err != nil {
-		r.log.Println(err)
+	if !client.unregistered.Swap(true) {
+		var err error
+		if e := r.do(func() { err = r.unregister(client) }); e == nil && err != nil {
+			client.log.Error("failed to unregister the client", "error", err)
+		}
 	}
+	r.closeClient(client)
+}
+
+// CloseConnectionFromService is CloseConnection called from the RoomService callbacks.
+// The client is unregistered on the event loop without waiting for it.
+func (r *Room) CloseConnectionFromService(client *Client) {
+	if !client.unregistered.Swap(true) {
+		if err := r.unregister(client); err != nil {
+			client.log.Error("failed to unregister the client", "error", err)
+		}
+	}
+	r.closeClient(client)
+}
+
+func (r *Room) closeClient(client *Client) {
 	if err := client.Close(); err != nil && err.Error() != "use of closed network connection" {
-		r.log.Println(err)
+		client.log.Debug("failed to close the connection", "error", err)
 	}
 }
 
-// Close closes all client connections.
+// Close closes all client connections, unregisters the room from the store and frees the room id.
+// Closing the closed room does nothing.
+// It must not be called from the event loop, which runs the RoomService callbacks.
+// The callbacks use CloseFromService instead.
 func (r *Room) Close() error {
-	r.clientManager.Lock()
-	defer r.clientManager.Unlock()
-	for _, client := range r.clientManager.GetAllClients() {
+	return r.closeBecause("room closed")
+}
+
+// CloseFromService is Close called from the RoomService callbacks.
+// The room is closed on the event loop without waiting for it.
+func (r *Room) CloseFromService() error {
+	if r.isClosed() {
+		return nil
+	}
+	return r.close("room closed")
+}
+
+// closeBecause is Close with the reason notified to the watchers.
+func (r *Room) closeBecause(reason string) error {
+	var err error
+	if e := r.do(func() { err = r.close(reason) }); e != nil {
+		return nil
+	}
+	return err
+}
+
+// close is Close processed on the event loop.
+func (r *Room) close(reason string) error {
+	close(r.closed)
+	r.notify(RoomClosed, -1, reason)
+
+	for _, client := range r.clients() {
 		if err := client.Close(); err != nil {
-			r.log.Println(err)
+			client.log.Debug("failed to close the connection", "error", err)
 		}
 	}
 
 	r.server.rooms.Delete(r.config.RoomID)
+	if !r.server.idLost.Load() {
+		if err := r.store.UnregisterRoom(r.roomProto); err != nil {
+			r.log.Error("failed to unregister the room", "error", err)
+		}
+	}
+	if err := r.server.idGenerator.Free(r.config.RoomID & roomIDMask); err != nil {
+		r.log.Error("failed to free the room id", "error", err)
+	}
+
 	return r.service.Destroy()
 }
+
+// closeWithMessage sends the message to all clients and closes the room after the message is written or the timeout.
+func (r *Room) closeWithMessage(message []byte, reason string, timeout time.Duration) error {
+	clients := r.clients()
+	for _, client := range clients {
+		client.Send(message)
+		client.closeAfterWrite()
+	}
+
+	deadline := time.After(timeout)
+	for _, client := range clients {
+		select {
+		case <-client.current.Load().writerDone:
+		case <-deadline:
+		}
+	}
+
+	return r.closeBecause(reason)
+}
+
+func (r *Room) isClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
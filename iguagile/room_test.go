@@ -0,0 +1,173 @@
+package iguagile
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoomConcurrentClients(t *testing.T) {
+	const (
+		clients  = 50
+		messages = 20
+	)
+
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RoutingServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.config.MaxUser = clients + 1
+
+	creator, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = io.Copy(io.Discard, creator)
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := connect(server, false)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+
+			for j := 0; j < messages; j++ {
+				target := []byte{AllClients, OtherClients, Host}[j%3]
+				if err := send(conn, append([]byte{target, UserMessage}, testData...)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			if err := conn.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for room.clientManager.Count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("clients are not unregistered %v", room.clientManager.Count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := creator.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := room.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := room.Close(); err != nil {
+		t.Errorf("closing the closed room returns error %v", err)
+	}
+}
+
+// closingService closes the room or the connection of the sender from Receive.
+type closingService struct {
+	*RelayService
+	room  *Room
+	close func(room *Room, senderID int)
+}
+
+func (s *closingService) Receive(senderID int, _ []byte) error {
+	s.close(s.room, senderID)
+	return nil
+}
+
+func TestCloseFromService(t *testing.T) {
+	tests := map[string]func(room *Room, senderID int){
+		"room": func(room *Room, _ int) {
+			if err := room.CloseFromService(); err != nil {
+				t.Error(err)
+			}
+		},
+		"connection": func(room *Room, senderID int) {
+			client, err := room.clientManager.Get(senderID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			room.CloseConnectionFromService(client)
+		},
+	}
+
+	for name, closeFrom := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, err := NewRoomServer(RelayServiceFactory{}, NewMemoryStore(), address)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			room, err := createRoomOn(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := room.do(func() {
+				room.service = &closingService{RelayService: &RelayService{room: room}, room: room, close: closeFrom}
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := connect(server, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			if err := send(conn, testData); err != nil {
+				t.Fatal(err)
+			}
+
+			// The connection is closed without blocking the event loop.
+			buf := make([]byte, maxMessageSize)
+			if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			for err == nil {
+				_, err = receive(conn, buf)
+			}
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				t.Fatal("the connection is not closed")
+			}
+
+			var count int
+			done := make(chan error, 1)
+			go func() {
+				done <- room.do(func() { count = room.clientManager.Count() })
+			}()
+			select {
+			case err := <-done:
+				if err == nil && count != 0 {
+					t.Errorf("the client is not unregistered %v", count)
+				}
+			case <-time.After(time.Second):
+				t.Error("the event loop is blocked")
+			}
+		})
+	}
+}
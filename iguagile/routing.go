@@ -0,0 +1,223 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Targets
+const (
+	AllClients = iota
+	OtherClients
+	Host
+	Server
+	SpecifiedClient
+	SpecifiedClients
+)
+
+// RoutingService is a service sends data to the clients specified by the target of the inbound data.
+//
+// The payload for SpecifiedClient starts with the 2 bytes client id.
+// The payload for SpecifiedClients starts with the 1 byte number of clients followed by the 2 bytes client ids.
+// Outbound data is prefixed with the 2 bytes sender id in the format NewOutBoundData parses.
+// Data sent to the Server is processed as a request such as instantiating a GameObject.
+// UpdateObject data starts with the 4 bytes object id and is dropped unless the sender owns the GameObject.
+// BufferedRPC data sent to all or other clients is replayed to clients join later.
+type RoutingService struct {
+	room            *Room
+	ownershipPolicy OwnershipPolicy
+}
+
+// Receive parses the inbound data and sends to the target clients.
+func (s *RoutingService) Receive(senderID int, data []byte) error {
+	inbound, err := NewInBoundData(data)
+	if err != nil {
+		return err
+	}
+
+	if inbound.MessageType == UpdateObject && !s.room.isOwner(senderID, inbound.Payload) {
+		s.room.log.Warn("client is not the owner of the updated object", "client_id", senderID)
+		return nil
+	}
+
+	switch inbound.Target {
+	case AllClients:
+		message := outboundMessage(senderID, data[1], inbound.Payload)
+		if err := s.bufferRPC(senderID, inbound, message); err != nil {
+			return err
+		}
+		s.room.sendToAllClients(senderID, message, inbound.Delivery)
+	case OtherClients:
+		message := outboundMessage(senderID, data[1], inbound.Payload)
+		if err := s.bufferRPC(senderID, inbound, message); err != nil {
+			return err
+		}
+		s.room.sendToOtherClients(senderID, message, inbound.Delivery)
+	case Host:
+		if err := s.room.sendToHost(senderID, outboundMessage(senderID, data[1], inbound.Payload), inbound.Delivery); err != nil {
+			s.room.log.Warn("failed to send to the host", "client_id", senderID, "error", err)
+		}
+	case Server:
+		return s.receiveServer(senderID, inbound)
+	case SpecifiedClient:
+		if len(inbound.Payload) < 2 {
+			return ErrInvalidDataFormat
+		}
+
+		targetID := int(binary.LittleEndian.Uint16(inbound.Payload))
+		s.room.sendToClient(targetID, senderID, outboundMessage(senderID, data[1], inbound.Payload[2:]), inbound.Delivery)
+	case SpecifiedClients:
+		if len(inbound.Payload) < 1 {
+			return ErrInvalidDataFormat
+		}
+
+		count := int(inbound.Payload[0])
+		headerSize := 1 + count*2
+		if len(inbound.Payload) < headerSize {
+			return ErrInvalidDataFormat
+		}
+
+		message := outboundMessage(senderID, data[1], inbound.Payload[headerSize:])
+		for i := 1; i < headerSize; i += 2 {
+			targetID := int(binary.LittleEndian.Uint16(inbound.Payload[i:]))
+			s.room.sendToClient(targetID, senderID, message, inbound.Delivery)
+		}
+	default:
+		return fmt.Errorf("invalid target %v", inbound.Target)
+	}
+
+	return nil
+}
+
+// receiveServer processes data sent to the server only.
+func (s *RoutingService) receiveServer(senderID int, data *BinaryData) error {
+	switch data.MessageType {
+	case Instantiate:
+		return s.room.instantiate(senderID, data.Payload)
+	case Destroy:
+		return s.room.destroy(senderID, data.Payload)
+	case RequestOwnership:
+		return s.room.requestOwnership(senderID, data.Payload, s.ownershipPolicy)
+	case GrantOwnership:
+		return s.room.answerOwnership(senderID, data.Payload, s.ownershipPolicy, true)
+	case DenyOwnership:
+		return s.room.answerOwnership(senderID, data.Payload, s.ownershipPolicy, false)
+	case TransferOwnership:
+		return s.room.transferOwnership(senderID, data.Payload)
+	case RequestHost:
+		s.room.requestHost(senderID)
+		return nil
+	case TransferHost:
+		return s.room.transferHost(senderID, data.Payload)
+	case DesignateHost:
+		return s.room.designateHost(senderID, data.Payload)
+	case RemoveBufferedRPC:
+		key, _, err := parseRPCKey(data.Payload)
+		if err != nil {
+			return err
+		}
+		if !s.room.isOwner(senderID, data.Payload) && (s.room.host == nil || s.room.host.GetID() != senderID) {
+			s.room.log.Warn("client cannot remove the buffered rpc", "client_id", senderID, "object_id", key.ObjectID)
+			return nil
+		}
+		if key.Method == "" {
+			s.room.rpcBufferManager.RemoveObject(key.ObjectID)
+		} else {
+			s.room.rpcBufferManager.RemoveKey(key)
+		}
+		return nil
+	case ClearBufferedRPC:
+		client, err := s.room.clientManager.Get(senderID)
+		if err != nil {
+			return err
+		}
+		s.room.rpcBufferManager.Remove(client)
+		return nil
+	default:
+		return nil
+	}
+}
+
+// bufferRPC buffers the BufferedRPC message to send to clients join later.
+// The payload starts with the 1 byte flags, the 4 bytes object id and the method prefixed with the 1 byte length.
+func (s *RoutingService) bufferRPC(senderID int, data *BinaryData, message []byte) error {
+	if data.MessageType != BufferedRPC {
+		return nil
+	}
+
+	if len(data.Payload) < 1 {
+		return ErrInvalidDataFormat
+	}
+
+	key, _, err := parseRPCKey(data.Payload[1:])
+	if err != nil {
+		return err
+	}
+
+	sender, err := s.room.clientManager.Get(senderID)
+	if err != nil {
+		return err
+	}
+
+	if data.Payload[0]&rpcKeyed != 0 {
+		s.room.rpcBufferManager.AddKeyed(message, sender, key)
+	} else {
+		s.room.rpcBufferManager.AddWithKey(message, sender, key)
+	}
+	return nil
+}
+
+// OnRegisterClient notifies the new client of the host, and other clients of the new client.
+// The first client is notified by the room when it becomes the host.
+func (s *RoutingService) OnRegisterClient(clientID int) error {
+	if host := s.room.host; host != nil && host.GetID() != clientID {
+		s.room.SendToClient(clientID, host.GetID(), outboundMessage(host.GetID(), ChangeHost, nil))
+	}
+	s.room.SendToOtherClients(clientID, outboundMessage(clientID, NewConnect, nil))
+	return nil
+}
+
+// OnUnregisterClient notifies other clients of the client exit.
+func (s *RoutingService) OnUnregisterClient(clientID int) error {
+	s.room.SendToOtherClients(clientID, outboundMessage(clientID, ExitConnect, nil))
+	return nil
+}
+
+// OnChangeHost notifies all clients of the new host.
+func (s *RoutingService) OnChangeHost(clientID int) error {
+	s.room.SendToAllClients(clientID, outboundMessage(clientID, ChangeHost, nil))
+	return nil
+}
+
+// Destroy for implement RoomService.
+func (s *RoutingService) Destroy() error {
+	return nil
+}
+
+// RoutingServiceFactory creates RoutingService.
+type RoutingServiceFactory struct {
+	// OwnershipPolicy decides who arbitrates ownership requests for GameObjects.
+	OwnershipPolicy OwnershipPolicy
+}
+
+// Create creates a RoutingService.
+func (f RoutingServiceFactory) Create(room *Room) (RoomService, error) {
+	return &RoutingService{room: room, ownershipPolicy: f.OwnershipPolicy}, nil
+}
+
+// systemSenderID is the sender id of messages sent by the server itself.
+// Client ids never reach it.
+const systemSenderID = 1<<16 - 1
+
+// systemMessage returns a message sent by the server itself.
+func systemMessage(messageType byte, payload []byte) []byte {
+	return outboundMessage(systemSenderID, messageType, payload)
+}
+
+// outboundMessage returns a message prefixed with the sender id and the message type.
+func outboundMessage(senderID int, messageType byte, payload []byte) []byte {
+	message := make([]byte, 3, 3+len(payload))
+	binary.LittleEndian.PutUint16(message, uint16(senderID))
+	message[2] = messageType
+	return append(message, payload...)
+}
@@ -0,0 +1,207 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// connect connects to the test room through a loopback connection buffers messages.
+func connect(server *RoomServer, creator bool) (net.Conn, error) {
+	return connectRoom(server, roomID, creator)
+}
+
+func connectRoom(server *RoomServer, roomID int, creator bool) (net.Conn, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	serverConn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handshake(clientConn, roomID, creator); err != nil {
+		return nil, err
+	}
+
+	return clientConn, server.Serve(serverConn)
+}
+
+func handshake(writer io.Writer, roomID int, creator bool) error {
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, uint32(roomID))
+	messages := [][]byte{id, []byte(appName), []byte(appVersion), []byte(password)}
+	if creator {
+		messages = append(messages, roomToken)
+	}
+
+	for _, message := range messages {
+		if err := send(writer, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func receiveOutbound(t *testing.T, reader io.Reader) *BinaryData {
+	t.Helper()
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(reader, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := NewOutBoundData(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestRoutingService(t *testing.T) {
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RoutingServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := receiveOutbound(t, host)
+	if data.MessageType != ChangeHost {
+		t.Fatalf("invalid message type %v", data.MessageType)
+	}
+	hostID := data.ID
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := receiveOutbound(t, guest); data.MessageType != ChangeHost || !bytes.Equal(data.ID, hostID) {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	joined := receiveOutbound(t, host)
+	if joined.MessageType != NewConnect {
+		t.Fatalf("invalid message type %v", joined.MessageType)
+	}
+	guestID := binary.LittleEndian.Uint16(joined.ID)
+
+	// The host sends to the guest only.
+	target := make([]byte, 2)
+	binary.LittleEndian.PutUint16(target, guestID)
+	if err := send(host, append([]byte{SpecifiedClient, UserMessage}, append(target, testData...)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data = receiveOutbound(t, guest)
+	if !bytes.Equal(data.ID, hostID) || data.MessageType != UserMessage || !bytes.Equal(data.Payload, testData) {
+		t.Errorf("invalid data %v", data)
+	}
+
+	// The guest sends to the host.
+	if err := send(guest, append([]byte{Host, UserMessage + 1}, testData...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data = receiveOutbound(t, host)
+	if !bytes.Equal(data.ID, joined.ID) || data.MessageType != UserMessage+1 || !bytes.Equal(data.Payload, testData) {
+		t.Errorf("invalid data %v", data)
+	}
+
+	// The guest sends to all clients including itself.
+	if err := send(guest, append([]byte{AllClients, UserMessage + 2}, testData...)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, conn := range []net.Conn{host, guest} {
+		data = receiveOutbound(t, conn)
+		if !bytes.Equal(data.ID, joined.ID) || data.MessageType != UserMessage+2 {
+			t.Errorf("invalid data %v", data)
+		}
+	}
+}
+
+func TestRemoveBufferedRPC(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = host.Close()
+	}()
+	hostID := int(binary.LittleEndian.Uint16(receiveOutbound(t, host).ID))
+
+	guest, err := connect(server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = guest.Close()
+	}()
+	guestID := int(binary.LittleEndian.Uint16(receiveOutbound(t, host).ID))
+
+	// The payload is the 4 bytes object id and the method prefixed with the 1 byte length.
+	remove := []byte{Server, RemoveBufferedRPC, 1, 0, 0, 0, 0}
+	tests := []struct {
+		senderID int
+		buffered int
+	}{
+		// The guest neither owns the object nor is the host.
+		{guestID, 1},
+		{hostID, 0},
+	}
+	for _, test := range tests {
+		var buffered int
+		if err := room.do(func() {
+			if len(room.rpcBufferManager.buffer) == 0 {
+				host, _ := room.clientManager.Get(hostID)
+				room.rpcBufferManager.AddWithKey(testData, host, RPCKey{ObjectID: 1, Method: "move"})
+			}
+			err = room.receive(test.senderID, remove)
+			buffered = len(room.rpcBufferManager.buffer)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buffered != test.buffered {
+			t.Errorf("invalid buffer %v %v %v", test.senderID, buffered, test.buffered)
+		}
+	}
+}
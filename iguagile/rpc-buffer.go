@@ -1,53 +1,158 @@
 package iguagile
 
 import (
+	"encoding/binary"
 	"sync"
 )
 
-// RPCBufferManager manages buffered rpc messages.
+// RPCKey identifies buffered rpc messages by the object and the method.
+type RPCKey struct {
+	ObjectID int
+	Method   string
+}
+
+// flags of the buffered rpc
+const (
+	// rpcKeyed replaces the buffered rpc message has the same key.
+	rpcKeyed = 1 << iota
+)
+
+type bufferedRPC struct {
+	seq uint64
+	// key is nil if the rpc message is added without the key.
+	key     *RPCKey
+	keyed   bool
+	sender  *Client
+	message []byte
+}
+
+// RPCBufferManager manages buffered rpc messages in order of arrival.
 type RPCBufferManager struct {
-	buffer map[*[]byte]*Client
+	buffer []*bufferedRPC
+	seq    uint64
 	sync.Mutex
 }
 
 // NewRPCBufferManager is RPCBufferManger constructed.
 func NewRPCBufferManager() *RPCBufferManager {
 	return &RPCBufferManager{
-		buffer: make(map[*[]byte]*Client),
-		Mutex:  sync.Mutex{},
+		Mutex: sync.Mutex{},
 	}
 }
 
 // Add new rpc message.
 func (m *RPCBufferManager) Add(message []byte, sender *Client) {
 	m.Lock()
-	m.buffer[&message] = sender
+	m.add(message, sender, nil, false)
 	m.Unlock()
 }
 
-// Remove rpc messages.
-func (m *RPCBufferManager) Remove(client *Client) {
+// AddWithKey adds new rpc message can be removed by the key and returns the sequence number.
+func (m *RPCBufferManager) AddWithKey(message []byte, sender *Client, key RPCKey) uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.add(message, sender, &key, false)
+}
+
+// AddKeyed adds new rpc message replaces the buffered rpc message has the same key and returns the sequence number.
+func (m *RPCBufferManager) AddKeyed(message []byte, sender *Client, key RPCKey) uint64 {
 	m.Lock()
-	for buffer, sender := range m.buffer {
-		if sender == client {
-			delete(m.buffer, buffer)
+	defer m.Unlock()
+	m.remove(func(rpc *bufferedRPC) bool {
+		return rpc.keyed && *rpc.key == key
+	})
+	return m.add(message, sender, &key, true)
+}
+
+func (m *RPCBufferManager) add(message []byte, sender *Client, key *RPCKey, keyed bool) uint64 {
+	m.seq++
+	m.buffer = append(m.buffer, &bufferedRPC{
+		seq:     m.seq,
+		key:     key,
+		keyed:   keyed,
+		sender:  sender,
+		message: message,
+	})
+	return m.seq
+}
+
+// remove rpc messages match the condition keeping the order.
+func (m *RPCBufferManager) remove(match func(rpc *bufferedRPC) bool) {
+	buffer := m.buffer[:0]
+	for _, rpc := range m.buffer {
+		if !match(rpc) {
+			buffer = append(buffer, rpc)
 		}
 	}
+	for i := len(buffer); i < len(m.buffer); i++ {
+		m.buffer[i] = nil
+	}
+	m.buffer = buffer
+}
+
+// Remove rpc messages sent by the client.
+func (m *RPCBufferManager) Remove(client *Client) {
+	m.Lock()
+	m.remove(func(rpc *bufferedRPC) bool {
+		return rpc.sender == client
+	})
+	m.Unlock()
+}
+
+// RemoveObject removes rpc messages for the object.
+func (m *RPCBufferManager) RemoveObject(objectID int) {
+	m.Lock()
+	m.remove(func(rpc *bufferedRPC) bool {
+		return rpc.key != nil && rpc.key.ObjectID == objectID
+	})
+	m.Unlock()
+}
+
+// RemoveKey removes rpc messages have the key.
+func (m *RPCBufferManager) RemoveKey(key RPCKey) {
+	m.Lock()
+	m.remove(func(rpc *bufferedRPC) bool {
+		return rpc.key != nil && *rpc.key == key
+	})
 	m.Unlock()
 }
 
 // Clear all rpc messages.
 func (m *RPCBufferManager) Clear() {
 	m.Lock()
-	m.buffer = make(map[*[]byte]*Client)
+	m.buffer = nil
 	m.Unlock()
 }
 
-// SendRPCBuffer sends all buffered rpc messages.
+// SendRPCBuffer sends all buffered rpc messages in order.
 func (m *RPCBufferManager) SendRPCBuffer(client *Client) {
 	m.Lock()
-	for buffer := range m.buffer {
-		client.Send(*buffer)
+	messages := make([][]byte, len(m.buffer))
+	for i, rpc := range m.buffer {
+		messages[i] = rpc.message
 	}
 	m.Unlock()
+
+	for _, message := range messages {
+		client.Send(message)
+	}
+}
+
+// parseRPCKey parses the 4 bytes object id and the method prefixed with the 1 byte length,
+// and returns the key and the rest of the payload.
+func parseRPCKey(payload []byte) (RPCKey, []byte, error) {
+	if len(payload) < 5 {
+		return RPCKey{}, nil, ErrInvalidDataFormat
+	}
+
+	size := int(payload[4])
+	if len(payload) < 5+size {
+		return RPCKey{}, nil, ErrInvalidDataFormat
+	}
+
+	key := RPCKey{
+		ObjectID: int(binary.LittleEndian.Uint32(payload)),
+		Method:   string(payload[5 : 5+size]),
+	}
+	return key, payload[5+size:], nil
 }
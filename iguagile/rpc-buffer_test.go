@@ -0,0 +1,46 @@
+package iguagile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRPCBufferManager(t *testing.T) {
+	alice, bob := &Client{id: 1}, &Client{id: 2}
+	m := NewRPCBufferManager()
+
+	m.AddWithKey([]byte("a"), alice, RPCKey{ObjectID: 1, Method: "move"})
+	m.AddKeyed([]byte("b"), bob, RPCKey{ObjectID: 1, Method: "color"})
+	m.AddWithKey([]byte("c"), bob, RPCKey{ObjectID: 2, Method: "move"})
+	m.AddKeyed([]byte("d"), alice, RPCKey{ObjectID: 1, Method: "color"})
+	m.AddWithKey([]byte("e"), alice, RPCKey{ObjectID: 3, Method: "jump"})
+	m.AddWithKey([]byte("f"), bob, RPCKey{ObjectID: 3, Method: "jump"})
+	m.Add([]byte("g"), alice)
+
+	receive := func() []string {
+		client := &Client{queue: newSendQueue(SendQueueConfig{Size: 10})}
+		m.SendRPCBuffer(client)
+		var messages []string
+		for message, ok := client.queue.pop(); ok; message, ok = client.queue.pop() {
+			messages = append(messages, string(message.data))
+		}
+		return messages
+	}
+
+	tests := []struct {
+		remove func()
+		want   []string
+	}{
+		{func() {}, []string{"a", "c", "d", "e", "f", "g"}},
+		{func() { m.RemoveKey(RPCKey{ObjectID: 3, Method: "jump"}) }, []string{"a", "c", "d", "g"}},
+		{func() { m.Remove(bob) }, []string{"a", "d", "g"}},
+		{func() { m.RemoveObject(1) }, []string{"g"}},
+		{func() { m.Remove(alice) }, nil},
+	}
+	for _, test := range tests {
+		test.remove()
+		if got := receive(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("invalid buffer %v, %v", got, test.want)
+		}
+	}
+}
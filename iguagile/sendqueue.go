@@ -0,0 +1,193 @@
+package iguagile
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SendPolicy decides what to do when the send queue of the client is full.
+type SendPolicy byte
+
+// Send policies
+const (
+	// DropOldestUnreliable drops the oldest queued unreliable message.
+	// If no unreliable message is queued, the new unreliable message is dropped,
+	// and the client is disconnected for the new reliable message.
+	DropOldestUnreliable SendPolicy = iota
+	// DropNewest drops the new message.
+	DropNewest
+	// DisconnectSlowClient disconnects the client immediately.
+	DisconnectSlowClient
+	// BlockWithTimeout waits for the queue to have room and disconnects the client on the timeout.
+	// Messages are sent on the event loop of the room, so the wait blocks the whole room.
+	BlockWithTimeout
+)
+
+// SendQueueConfig is config of the outbound message queue of each client.
+type SendQueueConfig struct {
+	Size   int
+	Policy SendPolicy
+	// Timeout is the wait of BlockWithTimeout.
+	Timeout time.Duration
+	// WriteTimeout limits the time to write a message to the connection. It is disabled if it is 0.
+	WriteTimeout time.Duration
+}
+
+var errSlowClient = errors.New("send queue of the client is full")
+
+// queuedMessage is the outbound message with the delivery mode it is written in.
+type queuedMessage struct {
+	data     []byte
+	delivery byte
+}
+
+// sendQueue is a bounded queue of outbound messages.
+type sendQueue struct {
+	config   SendQueueConfig
+	messages []queuedMessage
+	notEmpty chan struct{}
+	notFull  chan struct{}
+	dropped  atomic.Uint64
+	// total counts dropped messages of all queues sharing it.
+	total *atomic.Uint64
+	*sync.Mutex
+}
+
+func newSendQueue(config SendQueueConfig) *sendQueue {
+	if config.Size <= 0 {
+		config.Size = 1
+	}
+
+	return &sendQueue{
+		config:   config,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+		Mutex:    &sync.Mutex{},
+	}
+}
+
+// drop counts the dropped message.
+func (q *sendQueue) drop() {
+	q.dropped.Add(1)
+	if q.total != nil {
+		q.total.Add(1)
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// tryPush enqueues the message if the queue has room or the policy makes room.
+// It returns false if the message is neither enqueued nor dropped.
+func (q *sendQueue) tryPush(message queuedMessage) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.messages) < q.config.Size {
+		q.messages = append(q.messages, message)
+		signal(q.notEmpty)
+		return true
+	}
+
+	switch q.config.Policy {
+	case DropNewest:
+		q.drop()
+		return true
+	case DropOldestUnreliable:
+		for i, queued := range q.messages {
+			if queued.delivery != ReliableOrdered {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				q.messages = append(q.messages, message)
+				q.drop()
+				return true
+			}
+		}
+
+		if message.delivery != ReliableOrdered {
+			q.drop()
+			return true
+		}
+	}
+
+	return false
+}
+
+// push enqueues the message according to the policy.
+// It returns errSlowClient if the client should be disconnected.
+func (q *sendQueue) push(message queuedMessage, closing <-chan struct{}) error {
+	if q.tryPush(message) {
+		return nil
+	}
+
+	if q.config.Policy != BlockWithTimeout {
+		q.drop()
+		return errSlowClient
+	}
+
+	timer := time.NewTimer(q.config.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-q.notFull:
+			if q.tryPush(message) {
+				return nil
+			}
+		case <-timer.C:
+			q.drop()
+			return errSlowClient
+		case <-closing:
+			return nil
+		}
+	}
+}
+
+// pushOffline enqueues the message for the client is offline, regardless of the policy.
+// It returns false if the queue already has limit messages.
+func (q *sendQueue) pushOffline(message queuedMessage, limit int) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.messages) >= limit {
+		q.drop()
+		return false
+	}
+
+	q.messages = append(q.messages, message)
+	return true
+}
+
+// pushFront enqueues the reliable message to be written first.
+func (q *sendQueue) pushFront(message []byte) {
+	q.Lock()
+	defer q.Unlock()
+	q.messages = append([]queuedMessage{{data: message, delivery: ReliableOrdered}}, q.messages...)
+	signal(q.notEmpty)
+}
+
+// pop dequeues the oldest message.
+func (q *sendQueue) pop() (queuedMessage, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.messages) == 0 {
+		return queuedMessage{}, false
+	}
+
+	message := q.messages[0]
+	q.messages[0] = queuedMessage{}
+	q.messages = q.messages[1:]
+	signal(q.notFull)
+	return message, true
+}
+
+func (q *sendQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.messages)
+}
@@ -0,0 +1,134 @@
+package iguagile
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendQueue(t *testing.T) {
+	// The delivery mode of the queued message decides what is dropped, not the bytes of the message.
+	reliable := func(b byte) queuedMessage {
+		return queuedMessage{data: []byte{0, 0, Unreliable << deliveryShift, b}, delivery: ReliableOrdered}
+	}
+	unreliable := func(b byte) queuedMessage {
+		return queuedMessage{data: []byte{b}, delivery: Unreliable}
+	}
+
+	tests := []struct {
+		policy   SendPolicy
+		messages []queuedMessage
+		want     []queuedMessage
+		dropped  uint64
+		err      error
+	}{
+		{DropNewest, []queuedMessage{reliable(1), reliable(2), reliable(3)}, []queuedMessage{reliable(1), reliable(2)}, 1, nil},
+		{DropOldestUnreliable, []queuedMessage{reliable(1), unreliable(2), reliable(3)}, []queuedMessage{reliable(1), reliable(3)}, 1, nil},
+		{DropOldestUnreliable, []queuedMessage{reliable(1), reliable(2), unreliable(3)}, []queuedMessage{reliable(1), reliable(2)}, 1, nil},
+		{DropOldestUnreliable, []queuedMessage{reliable(1), reliable(2), reliable(3)}, []queuedMessage{reliable(1), reliable(2)}, 1, errSlowClient},
+		{BlockWithTimeout, []queuedMessage{reliable(1), reliable(2), reliable(3)}, []queuedMessage{reliable(1), reliable(2)}, 1, errSlowClient},
+		{DisconnectSlowClient, []queuedMessage{reliable(1), reliable(2), reliable(3)}, []queuedMessage{reliable(1), reliable(2)}, 1, errSlowClient},
+	}
+
+	var total atomic.Uint64
+	for _, test := range tests {
+		q := newSendQueue(SendQueueConfig{Size: 2, Policy: test.policy, Timeout: time.Millisecond * 10})
+		q.total = &total
+		var err error
+		for _, message := range test.messages {
+			if e := q.push(message, nil); e != nil {
+				err = e
+			}
+		}
+
+		if err != test.err {
+			t.Errorf("invalid error %v %v %v", test.policy, err, test.err)
+		}
+
+		if got := q.dropped.Load(); got != test.dropped {
+			t.Errorf("invalid dropped count %v %v %v", test.policy, got, test.dropped)
+		}
+
+		var got []queuedMessage
+		for message, ok := q.pop(); ok; message, ok = q.pop() {
+			got = append(got, message)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("invalid queue %v %v %v", test.policy, got, test.want)
+		}
+	}
+
+	if got := total.Load(); got != uint64(len(tests)) {
+		t.Errorf("invalid total dropped count %v", got)
+	}
+}
+
+func TestSendQueueBlock(t *testing.T) {
+	q := newSendQueue(SendQueueConfig{Size: 1, Policy: BlockWithTimeout, Timeout: time.Second})
+	if err := q.push(queuedMessage{data: []byte{1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- q.push(queuedMessage{data: []byte{2}}, nil)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	if message, ok := q.pop(); !ok || message.data[0] != 1 {
+		t.Fatalf("invalid message %v %v", message, ok)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if depth := q.len(); depth != 1 {
+		t.Errorf("invalid queue depth %v", depth)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	server, err := NewRoomServer(RelayServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SendQueue.WriteTimeout = 20 * time.Millisecond
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client never reads from the pipe.
+	conn, serverConn := net.Pipe()
+	defer func() {
+		_ = conn.Close()
+	}()
+	go func() {
+		_ = handshake(conn, roomID, true)
+	}()
+	if err := server.Serve(serverConn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := room.do(func() { room.SendToAllClients(0, testData) }); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var count int
+		if err := room.do(func() { count = room.clientManager.Count() }); err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client is not disconnected on the write timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
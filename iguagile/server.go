@@ -1,16 +1,17 @@
 package iguagile
 
 import (
-	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,15 +26,57 @@ type RoomServer struct {
 	factory              RoomServiceFactory
 	store                Store
 	idGenerator          *IDGenerator
-	logger               *log.Logger
+	events               *eventBroker
+	metrics              *serverMetrics
 	serverProto          *pb.Server
 	RoomUpdateDuration   time.Duration
 	ServerUpdateDuration time.Duration
+	// HostElection elects the new host of rooms created after it is set.
+	HostElection HostElection
+	// RoomLifecycle is rules to close rooms created after it is set.
+	RoomLifecycle RoomLifecycle
+	// SendQueue is config of the send queue of clients connecting to rooms created after it is set.
+	SendQueue SendQueueConfig
+	// Heartbeat is config of pings to clients connecting to rooms created after it is set.
+	Heartbeat HeartbeatConfig
+	// Resume is config of resuming sessions of clients connecting to rooms created after it is set.
+	Resume ResumeConfig
+	// Authenticator decides whether peers are admitted to rooms.
+	// The DefaultAuthenticator with Tickets and RequireTicket is used if it is nil.
+	Authenticator Authenticator
+	// Tickets verifies join tickets for the DefaultAuthenticator. Join tickets are not accepted if it is nil.
+	Tickets *TicketVerifier
+	// RequireTicket rejects the handshake without the join ticket for the DefaultAuthenticator.
+	RequireTicket bool
+	// HandshakeTimeout is the time limit of the handshake. It is disabled if it is 0.
+	HandshakeTimeout time.Duration
+	// Logger is the structured logger of the server and rooms created after it is set.
+	// Any slog.Handler can be injected with slog.New. The default writes JSON to stdout.
+	Logger *slog.Logger
+
+	draining   atomic.Bool
+	idLost     atomic.Bool
+	closed     chan struct{}
+	closeOnce  *sync.Once
+	mu         *sync.Mutex
+	listener   io.Closer
+	grpcServer *grpc.Server
 }
 
 // ErrPortIsOutOfRange is invalid ports request.
 var ErrPortIsOutOfRange = fmt.Errorf("port is out of range")
 
+// ErrServerClosed is returned by Run after the server is shut down.
+var ErrServerClosed = errors.New("room server closed")
+
+var errServerDraining = errors.New("room server is draining")
+
+const (
+	shutdownPollInterval = 100 * time.Millisecond
+	// Maximum time to wait for the server closing message to be written to clients.
+	shutdownWriteTimeout = time.Second
+)
+
 // NewRoomServer is a constructor of RoomServer.
 func NewRoomServer(factory RoomServiceFactory, store Store, address string) (*RoomServer, error) {
 	host, portStr, err := net.SplitHostPort(address)
@@ -69,20 +112,69 @@ func NewRoomServer(factory RoomServiceFactory, store Store, address string) (*Ro
 	}
 
 	return &RoomServer{
-		serverID:             serverID,
-		rooms:                &sync.Map{},
-		factory:              factory,
-		store:                store,
-		logger:               log.New(os.Stdout, "iguagile-server ", log.Lshortfile),
+		serverID: serverID,
+		rooms:    &sync.Map{},
+		factory:  factory,
+		store:    store,
+		Resume: ResumeConfig{
+			GracePeriod: time.Second * 10,
+			BufferSize:  256,
+		},
+		HandshakeTimeout:     time.Second * 10,
+		Logger:               slog.New(slog.NewJSONHandler(os.Stdout, nil)),
 		serverProto:          server,
 		RoomUpdateDuration:   time.Minute * 3,
 		ServerUpdateDuration: time.Minute * 3,
-		idGenerator:          idGenerator,
+		HostElection:         OldestClientElection{},
+		RoomLifecycle: RoomLifecycle{
+			UnclaimedTimeout: time.Minute * 5,
+			EmptyTimeout:     time.Minute,
+		},
+		SendQueue: SendQueueConfig{
+			Size:         256,
+			Policy:       DropOldestUnreliable,
+			WriteTimeout: time.Second * 10,
+		},
+		idGenerator: idGenerator,
+		events:      newEventBroker(),
+		metrics:     newServerMetrics(),
+		closed:      make(chan struct{}),
+		closeOnce:   &sync.Once{},
+		mu:          &sync.Mutex{},
 	}, nil
 }
 
 // Run starts api and room server.
 func (s *RoomServer) Run(roomListener net.Listener, apiPort int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := s.start(ctx, apiPort, roomListener); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := roomListener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			s.log().Error("failed to accept the connection", "error", err)
+			continue
+		}
+
+		// The handshake is processed on its own goroutine so that a slow peer does not block others.
+		go func() {
+			if err := s.Serve(conn); err != nil {
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
+// start starts api server and the goroutine updates the store periodically.
+// The room listener is closed when the server is shut down.
+func (s *RoomServer) start(ctx context.Context, apiPort int, roomListener io.Closer) error {
 	if apiPort > 65535 || apiPort < 0 {
 		return ErrPortIsOutOfRange
 	}
@@ -94,7 +186,13 @@ func (s *RoomServer) Run(roomListener net.Listener, apiPort int) error {
 		return err
 	}
 
+	s.mu.Lock()
+	s.listener = roomListener
+	s.grpcServer = server
+	s.mu.Unlock()
+
 	pb.RegisterRoomServiceServer(server, s)
+	RegisterRoomAdminServiceServer(server, s)
 	go func() {
 		_ = server.Serve(apiListener)
 	}()
@@ -103,137 +201,385 @@ func (s *RoomServer) Run(roomListener net.Listener, apiPort int) error {
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	go func(ctx context.Context) {
 		serverTicker := time.NewTicker(s.ServerUpdateDuration)
+		defer serverTicker.Stop()
 		roomTicker := time.NewTicker(s.RoomUpdateDuration)
+		defer roomTicker.Stop()
 		for {
 			select {
 			case <-serverTicker.C:
-				if err := s.store.RegisterServer(s.serverProto); err != nil {
-					s.logger.Println(err)
+				if !s.renewServerID() {
+					continue
+				}
+				register := s.store.RegisterServer
+				if s.draining.Load() {
+					register = s.store.DrainServer
+				}
+				if err := register(s.serverProto); err != nil {
+					s.log().Error("failed to register the server", "error", err)
 				}
 			case <-roomTicker.C:
+				if s.idLost.Load() {
+					continue
+				}
 				s.rooms.Range(func(_, value interface{}) bool {
 					room, ok := value.(*Room)
 					if !ok {
 						return true
 					}
-					if !room.creatorConnected {
+					if !room.creatorConnected.Load() {
 						return true
 					}
-					if err := s.store.RegisterRoom(room.roomProto); err != nil {
-						s.logger.Println(err)
-					}
+					_ = room.do(room.registerRoom)
 					return true
 				})
 			case <-ctx.Done():
 				return
+			case <-s.closed:
+				return
 			}
 		}
 	}(ctx)
 
-	for {
-		conn, err := roomListener.Accept()
-		if err != nil {
-			s.logger.Println(err)
-			continue
+	return nil
+}
+
+// renewServerID renews the lease of the server id, and returns false if the lease is lost.
+// The server losing the lease is drained, and no longer updates the store,
+// so that it does not collide with the server reusing the id.
+func (s *RoomServer) renewServerID() bool {
+	if s.idLost.Load() {
+		return false
+	}
+
+	err := s.store.RenewServerID(s.serverID)
+	if err == nil {
+		return true
+	}
+
+	s.log().Error("failed to renew the server id", "error", err)
+	if !errors.Is(err, ErrServerIDLost) {
+		return true
+	}
+
+	s.idLost.Store(true)
+	s.draining.Store(true)
+	return false
+}
+
+// Drain stops creating new rooms and reports the server is draining to the store.
+// Existing rooms keep running.
+func (s *RoomServer) Drain() error {
+	s.draining.Store(true)
+	if s.idLost.Load() {
+		return nil
+	}
+	return s.store.DrainServer(s.serverProto)
+}
+
+// Shutdown drains the server and waits until all rooms are closed or the context is done.
+// Clients of the remaining rooms are notified that the server is closing before the connections are closed.
+// Then the server is unregistered from the store, and Run returns ErrServerClosed.
+func (s *RoomServer) Shutdown(ctx context.Context) error {
+	if err := s.Drain(); err != nil {
+		s.log().Error("failed to drain the server", "error", err)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil && s.roomCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	s.rooms.Range(func(_, value interface{}) bool {
+		room, ok := value.(*Room)
+		if !ok {
+			return true
+		}
+		if err := room.closeWithMessage(systemMessage(ServerClosing, nil), "server closing", shutdownWriteTimeout); err != nil {
+			room.log.Error("failed to close the room", "error", err)
 		}
+		return true
+	})
 
-		if err := s.Serve(conn); err != nil {
-			s.logger.Println(err)
+	if !s.idLost.Load() {
+		if err := s.store.UnregisterServer(s.serverProto); err != nil {
+			s.log().Error("failed to unregister the server", "error", err)
+		}
+		if err := s.store.ReleaseServerID(s.serverID); err != nil {
+			s.log().Error("failed to release the server id", "error", err)
 		}
 	}
+
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.listener != nil {
+			if err := s.listener.Close(); err != nil {
+				s.log().Error("failed to close the listener", "error", err)
+			}
+		}
+		if s.grpcServer != nil {
+			s.grpcServer.Stop()
+		}
+	})
+
+	return err
+}
+
+func (s *RoomServer) roomCount() int {
+	count := 0
+	s.rooms.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func (s *RoomServer) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
 }
 
 // Serve handles requests from the peer.
+// Failed handshakes are logged and counted in the metrics by the reason.
 func (s *RoomServer) Serve(conn io.ReadWriteCloser) error {
-	client := &Client{conn: conn}
+	reason, err := s.serve(conn)
+	if err != nil {
+		s.metrics.handshakeFailed(reason)
+		s.log().Warn("handshake failed", "reason", reason, "error", err)
+	}
+	return err
+}
+
+// log returns the logger with the fields of the server.
+func (s *RoomServer) log() *slog.Logger {
+	return s.Logger.With("server_id", s.serverID)
+}
+
+// readHandshake reads the legacy or the versioned handshake request from the peer.
+// The legacy request has the token only if the peer is the creator of the room.
+// The legacy request is returned with the error, so that the failure is not replied to the legacy peer.
+func (s *RoomServer) readHandshake(conn io.Reader) (*HandshakeRequest, string, error) {
 	buf := make([]byte, maxMessageSize)
-	n, err := client.read(buf)
+	n, err := readMessage(conn, buf)
 	if err != nil {
-		return err
+		return nil, failedRead, err
 	}
 
 	if n != 4 {
-		return fmt.Errorf("invalid id length %v", buf[:n])
+		request, err := parseHandshakeRequest(buf[:n])
+		if errors.Is(err, errUnsupportedHandshake) {
+			return nil, failedUnsupported, err
+		}
+		if err != nil {
+			return nil, failedMalformed, err
+		}
+		return request, "", nil
 	}
 
-	roomID := int(binary.LittleEndian.Uint32(buf[:4]))
+	request := &HandshakeRequest{RoomID: int(binary.LittleEndian.Uint32(buf[:4]))}
+	room, err := s.lookupRoom(request.RoomID)
+	if err != nil {
+		return request, failedRoom, err
+	}
+
+	for _, field := range []*string{&request.ApplicationName, &request.Version, &request.Password} {
+		n, err := readMessage(conn, buf)
+		if err != nil {
+			return request, failedRead, err
+		}
+		*field = string(buf[:n])
+	}
+
+	if !room.creatorConnected.Load() {
+		n, err := readMessage(conn, buf)
+		if err != nil {
+			return request, failedRead, err
+		}
+		request.Token = append([]byte{}, buf[:n]...)
+	}
+
+	return request, "", nil
+}
+
+// lookupRoom returns the room on the server.
+func (s *RoomServer) lookupRoom(roomID int) (*Room, error) {
 	r, ok := s.rooms.Load(roomID)
 	if !ok {
-		return fmt.Errorf("the room does not exist %v", roomID)
+		return nil, fmt.Errorf("the room does not exist %v", roomID)
 	}
 
 	room, ok := r.(*Room)
 	if !ok {
-		return fmt.Errorf("invalid type %T", r)
+		return nil, fmt.Errorf("invalid type %T", r)
 	}
 
-	if room.clientManager.count >= room.config.MaxUser {
-		return fmt.Errorf("connected clients exceed room capacity %v %v", room.config.MaxUser, room.clientManager.count)
+	return room, nil
+}
+
+// admission is the accepted handshake.
+type admission struct {
+	room    *Room
+	creator bool
+	// identity is the identity of the join ticket and the metadata of the request, or nil.
+	identity *Identity
+}
+
+// acceptHandshake checks the request, and returns the room to join and whether the peer is the creator.
+// The Authenticator decides whether the peer is admitted after the room is found.
+// It returns the reason of the failure with the error.
+func (s *RoomServer) acceptHandshake(conn io.ReadWriteCloser, request *HandshakeRequest) (*admission, string, error) {
+	room, err := s.lookupRoom(request.RoomID)
+	if err != nil {
+		return nil, failedRoom, err
 	}
 
-	n, err = client.read(buf)
+	config, err := room.configSnapshot()
 	if err != nil {
-		return err
+		return nil, failedRoom, err
 	}
 
-	applicationName := string(buf[:n])
-	if applicationName != room.config.ApplicationName {
-		return fmt.Errorf("invalid application name %v %v", applicationName, room.config.ApplicationName)
+	// The resumed client keeps the place in the room, and the creator has already joined.
+	resume := len(request.ResumeToken) > 0
+	if !resume && room.clientManager.Count() >= config.MaxUser {
+		return nil, failedCapacity, fmt.Errorf("%w %v %v", errRoomFull, config.MaxUser, room.clientManager.Count())
 	}
 
-	n, err = client.read(buf)
-	if err != nil {
-		return err
+	if request.ApplicationName != config.ApplicationName {
+		return nil, failedApplication, fmt.Errorf("invalid application name %v %v", request.ApplicationName, config.ApplicationName)
 	}
 
-	version := string(buf[:n])
-	if version != room.config.Version {
-		return fmt.Errorf("invalid version %v %v", version, room.config.Version)
+	if request.Version != config.Version {
+		return nil, failedVersion, fmt.Errorf("invalid version %v %v", request.Version, config.Version)
 	}
 
-	n, err = client.read(buf)
+	creator := !resume && !room.creatorConnected.Load()
+	decision, err := s.authenticate(&AuthRequest{
+		Room:       config,
+		RemoteAddr: remoteAddress(conn),
+		Creator:    creator,
+		Resume:     resume,
+		Password:   request.Password,
+		Token:      request.Token,
+		Ticket:     request.Ticket,
+		Payload:    request.AuthPayload,
+	})
 	if err != nil {
-		return err
+		s.log().Error("failed to authenticate", "error", err)
+		return nil, failedAuth, errAuthFailed
+	}
+	if decision.Reason != nil {
+		return nil, authFailure(decision.Reason), decision.Reason
 	}
 
-	password := string(buf[:n])
-	if room.config.Password != "" && password != room.config.Password {
-		return fmt.Errorf("invalid password %v %v", password, room.config.Password)
+	return &admission{room: room, creator: creator, identity: joinIdentity(decision.Identity, request)}, "", nil
+}
+
+var errAuthFailed = errors.New("authentication failed")
+
+// authenticate asks the Authenticator for the decision in HandshakeTimeout.
+func (s *RoomServer) authenticate(request *AuthRequest) (*AuthDecision, error) {
+	authenticator := s.Authenticator
+	if authenticator == nil {
+		authenticator = &DefaultAuthenticator{Tickets: s.Tickets, RequireTicket: s.RequireTicket}
 	}
 
-	if !room.creatorConnected {
-		n, err := client.read(buf)
-		if err != nil {
-			return err
-		}
+	ctx := context.Background()
+	if s.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.HandshakeTimeout)
+		defer cancel()
+	}
 
-		if !bytes.Equal(buf[:n], room.config.Token) {
-			return fmt.Errorf("invalid token %v %v", buf[:n], room.config.Token)
-		}
+	decision, err := authenticator.Authenticate(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if decision == nil {
+		return nil, errors.New("no decision")
+	}
+	return decision, nil
+}
 
-		room.roomProto.ConnectedUser = 1
+var errHandshakeTimeout = errors.New("handshake timeout")
+
+// serve handles the handshake, and returns the reason of the failure with the error.
+// The connection is closed to interrupt reading if the handshake is not read in HandshakeTimeout.
+// The failure of the versioned handshake is replied to the peer.
+func (s *RoomServer) serve(conn io.ReadWriteCloser) (string, error) {
+	var timer *time.Timer
+	if s.HandshakeTimeout > 0 {
+		timer = time.AfterFunc(s.HandshakeTimeout, func() {
+			_ = conn.Close()
+		})
+	}
+
+	request, reason, err := s.readHandshake(conn)
+	if timer != nil && !timer.Stop() {
+		return failedTimeout, errHandshakeTimeout
+	}
 
-		if err := s.store.RegisterRoom(room.roomProto); err != nil {
-			return err
+	if err == nil {
+		var admitted *admission
+		admitted, reason, err = s.acceptHandshake(conn, request)
+		if err == nil && len(request.ResumeToken) > 0 {
+			err = admitted.room.resumeSession(conn, request.ResumeToken)
+			reason = joinFailure(err)
+		} else if err == nil {
+			err = admitted.room.serve(conn, admitted, request.handshakeVersion)
+			reason = joinFailure(err)
 		}
+	}
 
-		room.creatorConnected = true
+	if err != nil {
+		if e := rejectHandshake(conn, request, reason, err, s.HandshakeTimeout); e != nil {
+			s.log().Debug("failed to reply the handshake", "error", e)
+		}
 	}
+	return reason, err
+}
 
-	return room.serve(conn)
+// joinFailure returns the reason the room refused the client.
+func joinFailure(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, errRoomFull):
+		return failedCapacity
+	case errors.Is(err, errBannedHost), errors.Is(err, errBannedUser):
+		return failedBanned
+	case errors.Is(err, errRoomClosed):
+		return failedRoom
+	case errors.Is(err, errResumeFailed):
+		return failedResume
+	default:
+		return failedJoin
+	}
 }
 
 var errInvalidToken = fmt.Errorf("invalid room server api token")
 
 // CreateRoom creates new room.
 func (s *RoomServer) CreateRoom(ctx context.Context, request *pb.CreateRoomRequest) (*pb.CreateRoomResponse, error) {
-	if !bytes.Equal(request.ServerToken, s.serverProto.Token) {
-		return nil, errInvalidToken
+	if err := s.authorize(request.ServerToken); err != nil {
+		return nil, err
+	}
+
+	if s.draining.Load() {
+		return nil, errServerDraining
 	}
 
 	roomID, err := s.idGenerator.Generate()
@@ -265,17 +611,8 @@ func (s *RoomServer) CreateRoom(ctx context.Context, request *pb.CreateRoomReque
 	r.service = service
 
 	s.rooms.Store(roomID, r)
-
-	r.roomProto = &pb.Room{
-		RoomId:          int32(roomID),
-		RequirePassword: request.Password != "",
-		MaxUser:         request.MaxUser,
-		ConnectedUser:   0,
-		Server:          s.serverProto,
-		ApplicationName: request.ApplicationName,
-		Version:         request.Version,
-		Information:     request.Information,
-	}
+	r.notify(RoomCreated, -1, "")
+	go r.run(s.RoomLifecycle)
 
 	return &pb.CreateRoomResponse{Room: r.roomProto}, nil
 }
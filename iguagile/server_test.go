@@ -0,0 +1,307 @@
+package iguagile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/iguagile/iguagile-room-proto/room"
+)
+
+func TestShutdown(t *testing.T) {
+	store := NewMemoryStore()
+
+	server, err := NewRoomServer(RelayServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Run(listener, 0)
+	}()
+
+	request := &pb.CreateRoomRequest{
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		MaxUser:         10,
+		ServerToken:     server.serverProto.Token,
+		RoomToken:       roomToken,
+	}
+
+	response, err := server.CreateRoom(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handshake(conn, int(response.Room.RoomId), true); err != nil {
+		t.Fatal(err)
+	}
+
+	room, ok := server.rooms.Load(int(response.Room.RoomId))
+	if !ok {
+		t.Fatal("room does not exist")
+	}
+	for !room.(*Room).creatorConnected.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The room with the client is closed when the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := server.CreateRoom(context.Background(), request); !errors.Is(err, errServerDraining) {
+			t.Errorf("draining server created the room %v", err)
+		}
+	}()
+
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("invalid error %v", err)
+	}
+
+	data := receiveOutbound(t, conn)
+	if data.MessageType != ServerClosing {
+		t.Errorf("invalid data %v", data)
+	}
+
+	if _, err := receive(conn, make([]byte, maxMessageSize)); err != io.EOF {
+		t.Errorf("connection is not closed %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("invalid error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("server is not closed")
+	}
+
+	if err := store.RenewServerID(server.serverID); !errors.Is(err, ErrServerIDLost) {
+		t.Errorf("server id is not released %v", err)
+	}
+}
+
+func TestServerIDLost(t *testing.T) {
+	store := NewMemoryStore()
+	store.ServerIDTTL = 20 * time.Millisecond
+
+	server, err := NewRoomServer(RelayServiceFactory{}, store, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ServerUpdateDuration = 50 * time.Millisecond
+	server.RoomUpdateDuration = 20 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_ = server.Run(listener, 0)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if !server.draining.Load() {
+		t.Error("server losing the server id is not drained")
+	}
+
+	// The room of the server reusing the id is neither overwritten nor unregistered.
+	if err := store.RegisterRoom(&pb.Room{RoomId: roomID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createRoomOn(server); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("invalid error %v", err)
+	}
+
+	room, err := store.Room(roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.ConnectedUser != 0 || room.Server != nil {
+		t.Errorf("room is registered after the server id is lost %v", room)
+	}
+}
+
+// syncBuffer is a buffer written by goroutines.
+type syncBuffer struct {
+	buf bytes.Buffer
+	*sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestServerLogger(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &syncBuffer{Mutex: &sync.Mutex{}}
+	server.Logger = slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := connect(server, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveOutbound(t, host)
+
+	const secret = "secret password"
+	if err := room.do(func() { room.config.Password = secret }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connect(server, false); err == nil {
+		t.Error("invalid password is accepted")
+	}
+
+	if err := host.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	logs := output.String()
+	if strings.Contains(logs, secret) || strings.Contains(logs, password) {
+		t.Errorf("password is logged %v", logs)
+	}
+
+	var handshake, disconnected map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+
+		switch record["msg"] {
+		case "handshake failed":
+			handshake = record
+		case "disconnected":
+			disconnected = record
+		}
+	}
+
+	if handshake == nil || handshake["level"] != "WARN" || handshake["reason"] != failedPassword || handshake["server_id"] != float64(server.serverID) {
+		t.Errorf("invalid handshake log %v", handshake)
+	}
+	if disconnected == nil || disconnected["level"] != "DEBUG" || disconnected["room_id"] != float64(roomID) ||
+		disconnected["application"] != appName || disconnected["client_id"] == nil || disconnected["server_id"] != float64(server.serverID) {
+		t.Errorf("invalid client log %v", disconnected)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	server, err := NewRoomServer(RelayServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.HandshakeTimeout = 50 * time.Millisecond
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Run(listener, 0)
+	}()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	// The peer sends nothing.
+	silent, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = silent.Close()
+	}()
+
+	// The silent peer does not block the handshake of others.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err := handshake(conn, roomID, true); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for room.clientManager.Count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if room.clientManager.Count() != 1 {
+		t.Error("handshake is blocked by the silent peer")
+	}
+
+	if err := silent.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := silent.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("silent peer is not disconnected %v", err)
+	}
+
+	server.metrics.Lock()
+	failures := server.metrics.handshakeFailures[failedTimeout]
+	server.metrics.Unlock()
+	if failures != 1 {
+		t.Errorf("invalid handshake failures %v", failures)
+	}
+}
@@ -1,6 +1,8 @@
 package iguagile
 
 // RoomService implements the processing performed by the room
+// The methods are called on the event loop of the room.
+// They close the room and the connections with Room.CloseFromService and Room.CloseConnectionFromService.
 type RoomService interface {
 	// Receive processes data sent from the client to the server.
 	Receive(senderID int, data []byte) error
@@ -18,6 +20,13 @@ type RoomService interface {
 	Destroy() error
 }
 
+// IdentityService is the RoomService receives identities of clients.
+// OnIdentifyClient is called before OnRegisterClient for the client joined with the identity.
+// Identities are also readable with Room.Identity.
+type IdentityService interface {
+	OnIdentifyClient(clientID int, identity *Identity) error
+}
+
 // RoomServiceFactory creates RoomServices.
 type RoomServiceFactory interface {
 	// Create creates a RoomService.
@@ -1,24 +1,121 @@
 package iguagile
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/golang/protobuf/proto"
 	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
 	pb "github.com/iguagile/iguagile-room-proto/room"
 )
 
 // Store is an interface for connecting to backend storage and storing data.
 type Store interface {
 	Close() error
+	// GenerateServerID leases an unused server id. The lease expires unless it is renewed.
 	GenerateServerID() (int, error)
+	// RenewServerID extends the lease of the server id, or returns ErrServerIDLost if the lease expired.
+	RenewServerID(serverID int) error
+	// ReleaseServerID returns the server id to the pool.
+	ReleaseServerID(serverID int) error
 	RegisterServer(*pb.Server) error
 	UnregisterServer(*pb.Server) error
+	DrainServer(*pb.Server) error
 	RegisterRoom(*pb.Room) error
 	UnregisterRoom(*pb.Room) error
+	// Servers returns the servers accept new rooms.
+	Servers() ([]*pb.Server, error)
+	// Server returns the server accepts new rooms, or ErrNotFound.
+	Server(serverID int) (*pb.Server, error)
+	// Rooms returns the rooms the creator connected to.
+	Rooms() ([]*pb.Room, error)
+	// Room returns the room, or ErrNotFound.
+	Room(roomID int) (*pb.Room, error)
 }
 
+// ErrNotFound is returned when the server or the room is not registered.
+var ErrNotFound = errors.New("not found in the store")
+
+// ErrServerIDExhausted is returned when all server ids are leased.
+var ErrServerIDExhausted = errors.New("server id space exhausted")
+
+// ErrServerIDLost is returned when the lease of the server id expired.
+// The server id may be used by another server.
+var ErrServerIDLost = errors.New("server id lease lost")
+
+// maxServerID is the maximum server id, so that room ids fit in int32.
+const maxServerID = 1<<15 - 1
+
 // Redis is a structure that wraps goredis to make it easy to use.
+// Servers and rooms are published on channels and kept in keys expire after RegistryTTL.
+// Publishing while redis is unreachable is buffered and retried with backoff,
+// so that the state of servers and rooms is published in order after redis recovers.
 type Redis struct {
-	conn redis.Conn
+	pool        *redis.Pool
+	config      RedisConfig
+	leaseToken  string
+	maxServerID int
+	// serverIDKey is the counter of server ids, and the prefix of the keys of leases.
+	serverIDKey string
+	pending     []redisCommand
+	retrying    bool
+	flushing    bool
+	// running is held while the command of do runs, so that commands are run or buffered in order.
+	running *sync.Mutex
+	closed  chan struct{}
+	*sync.Mutex
+}
+
+// RedisConfig is config of the connections to redis.
+type RedisConfig struct {
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxIdle is the maximum number of idle connections in the pool.
+	MaxIdle int
+	// IdleTimeout closes connections idle longer than it.
+	IdleTimeout time.Duration
+	// HealthCheckInterval is the idle time after which connections are checked by PING before use.
+	HealthCheckInterval time.Duration
+	// MinBackoff and MaxBackoff are the range of the interval to retry buffered commands.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxPending is the maximum number of buffered commands. The oldest command is dropped when it is exceeded.
+	// Zero means no limit.
+	MaxPending int
+	// RegistryTTL is the time servers and rooms are kept without being registered again.
+	// It should be longer than the update durations of RoomServer.
+	RegistryTTL time.Duration
+	// ServerIDTTL is the lease time of server ids.
+	// It should be longer than the server update duration of RoomServer.
+	ServerIDTTL time.Duration
+}
+
+// DefaultRedisConfig returns the config used by NewRedis.
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		DialTimeout:         time.Second * 5,
+		ReadTimeout:         time.Second * 5,
+		WriteTimeout:        time.Second * 5,
+		MaxIdle:             8,
+		IdleTimeout:         time.Minute * 5,
+		HealthCheckInterval: time.Minute,
+		MinBackoff:          time.Millisecond * 100,
+		MaxBackoff:          time.Second * 30,
+		MaxPending:          1024,
+		RegistryTTL:         time.Minute * 10,
+		ServerIDTTL:         time.Minute * 10,
+	}
+}
+
+// redisCommand is a command buffered while redis is unreachable.
+type redisCommand struct {
+	name string
+	args []interface{}
 }
 
 const (
@@ -26,13 +123,91 @@ const (
 	unregisterServerMessage
 	registerRoomMessage
 	unregisterRoomMessage
+	drainServerMessage
 )
 
+var (
+	// claimServerIDScript leases the first unused server id from the counter.
+	claimServerIDScript = redis.NewScript(1, `
+local max = tonumber(ARGV[3])
+local start = redis.call('INCR', KEYS[1])
+for i = 0, max - 1 do
+	local id = (start + i - 1) % max + 1
+	if redis.call('SET', KEYS[1] .. ':' .. id, ARGV[1], 'NX', 'PX', ARGV[2]) then
+		return id
+	end
+end
+return 0
+`)
+
+	// renewServerIDScript extends the lease only if it is owned by the caller.
+	renewServerIDScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	// releaseServerIDScript deletes the lease only if it is owned by the caller.
+	releaseServerIDScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+)
+
+func (r *Redis) leaseKey(serverID int) string {
+	return fmt.Sprintf("%s:%d", r.serverIDKey, serverID>>16)
+}
+
 // GenerateServerID is a method to number unique ServerID.
+// The id is leased for ServerIDTTL, and ids whose lease expired are reused.
 func (r *Redis) GenerateServerID() (int, error) {
-	// TODO CHECK TO 1 << 16 over.
-	i, err := redis.Int(r.conn.Do("INCR", "server_id"))
-	return i << 16, err
+	conn := r.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	i, err := redis.Int(claimServerIDScript.Do(conn, r.serverIDKey, r.leaseToken, r.config.ServerIDTTL.Milliseconds(), r.maxServerID))
+	if err != nil {
+		return 0, err
+	}
+
+	if i == 0 {
+		return 0, ErrServerIDExhausted
+	}
+
+	return i << 16, nil
+}
+
+// RenewServerID extends the lease of the server id.
+func (r *Redis) RenewServerID(serverID int) error {
+	conn := r.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	renewed, err := redis.Bool(renewServerIDScript.Do(conn, r.leaseKey(serverID), r.leaseToken, r.config.ServerIDTTL.Milliseconds()))
+	if err != nil {
+		return err
+	}
+
+	if !renewed {
+		return ErrServerIDLost
+	}
+	return nil
+}
+
+// ReleaseServerID deletes the lease of the server id.
+func (r *Redis) ReleaseServerID(serverID int) error {
+	conn := r.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err := releaseServerIDScript.Do(conn, r.leaseKey(serverID), r.leaseToken)
+	return err
 }
 
 // RegisterServer registers server to redis.
@@ -44,12 +219,11 @@ func (r *Redis) RegisterServer(server *pb.Server) error {
 
 	message := append([]byte{registerServerMessage}, serverProto...)
 
-	_, err = r.conn.Do("PUBLISH", "channel_servers", message)
-	if err != nil {
+	if err := r.do("SET", serverKey(server.ServerId), serverProto, "PX", r.config.RegistryTTL.Milliseconds()); err != nil {
 		return err
 	}
 
-	return nil
+	return r.do("PUBLISH", "channel_servers", message)
 }
 
 // UnregisterServer unregisters server from redis.
@@ -61,12 +235,28 @@ func (r *Redis) UnregisterServer(server *pb.Server) error {
 
 	message := append([]byte{unregisterServerMessage}, serverProto...)
 
-	_, err = r.conn.Do("PUBLISH", "channel_servers", message)
+	if err := r.do("DEL", serverKey(server.ServerId)); err != nil {
+		return err
+	}
+
+	return r.do("PUBLISH", "channel_servers", message)
+}
+
+// DrainServer notifies the server does not accept new rooms.
+// Rooms of the server remain registered.
+func (r *Redis) DrainServer(server *pb.Server) error {
+	serverProto, err := proto.Marshal(server)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	message := append([]byte{drainServerMessage}, serverProto...)
+
+	if err := r.do("DEL", serverKey(server.ServerId)); err != nil {
+		return err
+	}
+
+	return r.do("PUBLISH", "channel_servers", message)
 }
 
 // RegisterRoom register room to redis.
@@ -78,12 +268,11 @@ func (r *Redis) RegisterRoom(room *pb.Room) error {
 
 	message := append([]byte{registerRoomMessage}, serverProto...)
 
-	_, err = r.conn.Do("PUBLISH", "channel_rooms", message)
-	if err != nil {
+	if err := r.do("SET", roomKey(room.RoomId), serverProto, "PX", r.config.RegistryTTL.Milliseconds()); err != nil {
 		return err
 	}
 
-	return nil
+	return r.do("PUBLISH", "channel_rooms", message)
 }
 
 // UnregisterRoom unregisters room from redis.
@@ -95,24 +284,331 @@ func (r *Redis) UnregisterRoom(room *pb.Room) error {
 
 	message := append([]byte{unregisterRoomMessage}, serverProto...)
 
-	_, err = r.conn.Do("PUBLISH", "channel_rooms", message)
-	if err != nil {
+	if err := r.do("DEL", roomKey(room.RoomId)); err != nil {
 		return err
 	}
 
+	return r.do("PUBLISH", "channel_rooms", message)
+}
+
+func serverKey(serverID int32) string {
+	return fmt.Sprintf("server:%d", serverID)
+}
+
+func roomKey(roomID int32) string {
+	return fmt.Sprintf("room:%d", roomID)
+}
+
+// Servers returns the servers accept new rooms ordered by the server id.
+func (r *Redis) Servers() ([]*pb.Server, error) {
+	values, err := r.scan("server:*")
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*pb.Server, 0, len(values))
+	for _, value := range values {
+		server := &pb.Server{}
+		if err := proto.Unmarshal(value, server); err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ServerId < servers[j].ServerId
+	})
+	return servers, nil
+}
+
+// Server returns the server accepts new rooms.
+func (r *Redis) Server(serverID int) (*pb.Server, error) {
+	value, err := r.get(serverKey(int32(serverID)))
+	if err != nil {
+		return nil, err
+	}
+
+	server := &pb.Server{}
+	if err := proto.Unmarshal(value, server); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Rooms returns the registered rooms ordered by the room id.
+func (r *Redis) Rooms() ([]*pb.Room, error) {
+	values, err := r.scan("room:*")
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]*pb.Room, 0, len(values))
+	for _, value := range values {
+		room := &pb.Room{}
+		if err := proto.Unmarshal(value, room); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomId < rooms[j].RoomId
+	})
+	return rooms, nil
+}
+
+// Room returns the registered room.
+func (r *Redis) Room(roomID int) (*pb.Room, error) {
+	value, err := r.get(roomKey(int32(roomID)))
+	if err != nil {
+		return nil, err
+	}
+
+	room := &pb.Room{}
+	if err := proto.Unmarshal(value, room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+func (r *Redis) get(key string) ([]byte, error) {
+	conn := r.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	value, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// scan returns the values of the keys match the pattern.
+// Keys expired while scanning are skipped.
+func (r *Redis) scan(pattern string) ([][]byte, error) {
+	conn := r.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var values [][]byte
+	cursor := 0
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(reply) != 2 {
+			return nil, fmt.Errorf("invalid scan reply %v", reply)
+		}
+
+		cursor, err = redis.Int(reply[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err := redis.Values(reply[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			found, err := redis.ByteSlices(conn.Do("MGET", keys...))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, value := range found {
+				if value != nil {
+					values = append(values, value)
+				}
+			}
+		}
+
+		if cursor == 0 {
+			return values, nil
+		}
+	}
+}
+
+// do runs the command, or buffers it to retry later if redis is unreachable.
+// Commands are run in order, so a command waits for the older command,
+// and is buffered while older commands are buffered.
+// The command found redis unreachable waits up to the timeouts of the RedisConfig.
+// After that, commands are buffered without dialing until the retry succeeds.
+func (r *Redis) do(name string, args ...interface{}) error {
+	r.running.Lock()
+	defer r.running.Unlock()
+
+	r.Lock()
+	retrying := r.retrying
+	r.Unlock()
+	if !retrying {
+		err := r.exec(name, args...)
+		if !isConnectionError(err) {
+			return err
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.buffer(redisCommand{name: name, args: args})
 	return nil
 }
 
+// buffer appends the command to the buffered commands and starts retrying.
+// The oldest command is dropped if the buffer is full, except the command being retried.
+func (r *Redis) buffer(command redisCommand) {
+	if r.config.MaxPending > 0 && len(r.pending) >= r.config.MaxPending {
+		i := 0
+		if r.flushing {
+			i = 1
+		}
+		if i < len(r.pending) {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+		}
+	}
+	r.pending = append(r.pending, command)
+
+	if !r.retrying {
+		r.retrying = true
+		go r.retryStart()
+	}
+}
+
+func (r *Redis) exec(name string, args ...interface{}) error {
+	conn := r.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err := conn.Do(name, args...)
+	return err
+}
+
+// isConnectionError checks the error is caused by the connection rather than the command.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
+
+// retryStart retries buffered commands with exponential backoff until all commands are run.
+func (r *Redis) retryStart() {
+	backoff := r.config.MinBackoff
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.closed:
+			timer.Stop()
+			return
+		}
+
+		if r.flush() {
+			return
+		}
+
+		backoff *= 2
+		if backoff > r.config.MaxBackoff {
+			backoff = r.config.MaxBackoff
+		}
+	}
+}
+
+// flush runs buffered commands in order and returns true if all commands are run.
+// Commands rejected by redis are dropped.
+// Commands are run without the lock, so that callers buffer new commands during the retry.
+func (r *Redis) flush() bool {
+	for {
+		r.Lock()
+		if len(r.pending) == 0 {
+			r.retrying = false
+			r.Unlock()
+			return true
+		}
+		command := r.pending[0]
+		r.flushing = true
+		r.Unlock()
+
+		err := r.exec(command.name, command.args...)
+
+		r.Lock()
+		r.flushing = false
+		if isConnectionError(err) {
+			r.Unlock()
+			return false
+		}
+		r.pending[0] = redisCommand{}
+		r.pending = r.pending[1:]
+		r.Unlock()
+	}
+}
+
 // Close is a method to release resources collectively.
+// Buffered commands are discarded.
 func (r *Redis) Close() error {
-	return r.conn.Close()
+	r.Lock()
+	defer r.Unlock()
+
+	select {
+	case <-r.closed:
+		return nil
+	default:
+	}
+
+	close(r.closed)
+	return r.pool.Close()
 }
 
 // NewRedis is a constructor of Redis. Perform initialization at once.
 func NewRedis(hostname string) (*Redis, error) {
-	conn, err := redis.Dial("tcp", hostname)
+	return NewRedisWithConfig(hostname, DefaultRedisConfig())
+}
+
+// NewRedisWithConfig is a constructor of Redis with the config.
+// It returns error if redis is unreachable at first.
+func NewRedisWithConfig(hostname string, config RedisConfig) (*Redis, error) {
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", hostname,
+				redis.DialConnectTimeout(config.DialTimeout),
+				redis.DialReadTimeout(config.ReadTimeout),
+				redis.DialWriteTimeout(config.WriteTimeout),
+			)
+		},
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			if time.Since(t) < config.HealthCheckInterval {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+		MaxIdle:     config.MaxIdle,
+		IdleTimeout: config.IdleTimeout,
+	}
+
+	conn := pool.Get()
+	_, err := conn.Do("PING")
+	_ = conn.Close()
 	if err != nil {
+		_ = pool.Close()
 		return nil, err
 	}
-	return &Redis{conn}, nil
+
+	return &Redis{
+		pool:        pool,
+		config:      config,
+		leaseToken:  uuid.New().String(),
+		maxServerID: maxServerID,
+		serverIDKey: "server_id",
+		running:     &sync.Mutex{},
+		closed:      make(chan struct{}),
+		Mutex:       &sync.Mutex{},
+	}, nil
 }
@@ -1,24 +1,405 @@
 package iguagile
 
 import (
+	"io"
+	"net"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gomodule/redigo/redis"
+	pb "github.com/iguagile/iguagile-room-proto/room"
 )
 
-func TestCanGenerateServerID(t *testing.T) {
-	store, err := NewRedis(os.Getenv("REDIS_HOST"))
+// testStore is the conformance test suite every Store must pass.
+// Other servers and rooms may be registered in the store.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	defer func() {
+		_ = store.Close()
+	}()
+
+	ids := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		id, err := store.GenerateServerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (id & 0xffff) != 0 {
+			t.Errorf("invalid server id %b", id)
+		}
+
+		if ids[id] {
+			t.Errorf("duplicate server id %v", id)
+		}
+		ids[id] = true
+	}
+
+	for id := range ids {
+		if err := store.RenewServerID(id); err != nil {
+			t.Error(err)
+		}
+		if err := store.ReleaseServerID(id); err != nil {
+			t.Error(err)
+		}
+		if err := store.RenewServerID(id); err != ErrServerIDLost {
+			t.Errorf("released server id is renewed %v %v", id, err)
+		}
+	}
+
+	server := &pb.Server{Host: "localhost", Port: 4000, ServerId: 0x7fff << 16, Token: []byte{1}}
+	room := &pb.Room{RoomId: 0x7fff<<16 | 1, MaxUser: 10, Server: server, ApplicationName: appName, Version: appVersion}
+
+	verify := func(serverRegistered, roomRegistered bool) {
+		t.Helper()
+		servers, err := store.Servers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, s := range servers {
+			found = found || s.ServerId == server.ServerId
+		}
+		if found != serverRegistered {
+			t.Errorf("invalid servers %v %v", servers, serverRegistered)
+		}
+
+		s, err := store.Server(int(server.ServerId))
+		if serverRegistered && (err != nil || s.Host != server.Host || s.Port != server.Port) {
+			t.Errorf("invalid server %v %v", s, err)
+		}
+		if !serverRegistered && err != ErrNotFound {
+			t.Errorf("invalid error %v", err)
+		}
+
+		rooms, err := store.Rooms()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = false
+		for _, r := range rooms {
+			found = found || r.RoomId == room.RoomId
+		}
+		if found != roomRegistered {
+			t.Errorf("invalid rooms %v %v", rooms, roomRegistered)
+		}
+
+		r, err := store.Room(int(room.RoomId))
+		if roomRegistered && (err != nil || r.ApplicationName != appName || r.Server.ServerId != server.ServerId) {
+			t.Errorf("invalid room %v %v", r, err)
+		}
+		if !roomRegistered && err != ErrNotFound {
+			t.Errorf("invalid error %v", err)
+		}
+	}
+
+	verify(false, false)
+
+	if err := store.RegisterServer(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RegisterRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	verify(true, true)
+
+	// The registered room is not changed by the caller.
+	room.ConnectedUser = 5
+	r, err := store.Room(int(room.RoomId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ConnectedUser != 0 {
+		t.Errorf("invalid room %v", r)
+	}
+	room.ConnectedUser = 0
+
+	if err := store.DrainServer(server); err != nil {
+		t.Fatal(err)
+	}
+	verify(false, true)
+
+	if err := store.RegisterServer(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UnregisterRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	verify(true, false)
+
+	if err := store.UnregisterServer(server); err != nil {
+		t.Fatal(err)
+	}
+	verify(false, false)
+}
+
+func TestRedis(t *testing.T) {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+
+	store, err := NewRedis(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+func TestRedisRegistryTTL(t *testing.T) {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+
+	store, err := NewRedis(host)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
 		_ = store.Close()
 	}()
+
+	room := &pb.Room{RoomId: 0x7fff<<16 | 2}
+	if err := store.RegisterRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.UnregisterRoom(room)
+	}()
+
+	conn := store.pool.Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	ttl, err := redis.Int64(conn.Do("PTTL", roomKey(room.RoomId)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > store.config.RegistryTTL.Milliseconds() {
+		t.Errorf("invalid ttl %v", ttl)
+	}
+}
+
+func TestRedisServerIDExhausted(t *testing.T) {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+
+	store, err := NewRedis(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	// The ids are not shared with the servers of other tests.
+	store.maxServerID = 2
+	store.serverIDKey = "server_id_" + store.leaseToken
+
+	var ids []int
+	for i := 0; i < 2; i++ {
+		id, err := store.GenerateServerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := store.GenerateServerID(); err != ErrServerIDExhausted {
+		t.Errorf("invalid error %v", err)
+	}
+
+	if err := store.ReleaseServerID(ids[0]); err != nil {
+		t.Fatal(err)
+	}
 	id, err := store.GenerateServerID()
+	if err != nil || id != ids[0] {
+		t.Errorf("released server id is not reused %v %v %v", id, ids[0], err)
+	}
+
+	for _, id := range ids {
+		if err := store.ReleaseServerID(id); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMemoryStoreServerIDLease(t *testing.T) {
+	store := NewMemoryStore()
+	store.maxServerID = 2
+	store.ServerIDTTL = 20 * time.Millisecond
+
+	first, err := store.GenerateServerID()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	if _, err := store.GenerateServerID(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GenerateServerID(); err != ErrServerIDExhausted {
+		t.Errorf("invalid error %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := store.RenewServerID(first); err != ErrServerIDLost {
+		t.Errorf("expired server id is renewed %v", err)
+	}
+
+	id, err := store.GenerateServerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id&0xffff != 0 || id>>16 < 1 || id>>16 > 2 {
+		t.Errorf("invalid server id %v", id)
+	}
+}
+
+// redisProxy forwards connections to redis, and can be stopped to simulate an outage.
+type redisProxy struct {
+	address  string
+	target   string
+	listener net.Listener
+	conns    []net.Conn
+	*sync.Mutex
+}
+
+func (p *redisProxy) start() error {
+	listener, err := net.Listen("tcp", p.address)
+	if err != nil {
+		return err
+	}
+	p.address = listener.Addr().String()
+	p.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			target, err := net.Dial("tcp", p.target)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+
+			p.Lock()
+			p.conns = append(p.conns, conn, target)
+			p.Unlock()
+			go func() {
+				_, _ = io.Copy(target, conn)
+				_ = target.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(conn, target)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return nil
+}
+
+func (p *redisProxy) stop() {
+	_ = p.listener.Close()
+	p.Lock()
+	defer p.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func TestRedisOutage(t *testing.T) {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+
+	proxy := &redisProxy{address: "localhost:0", target: host, Mutex: &sync.Mutex{}}
+	if err := proxy.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultRedisConfig()
+	config.MinBackoff = 10 * time.Millisecond
+	config.MaxBackoff = 50 * time.Millisecond
+	config.HealthCheckInterval = 0
+	store, err := NewRedisWithConfig(proxy.address, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	subscriber, err := redis.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psc := redis.PubSubConn{Conn: subscriber}
+	defer func() {
+		_ = psc.Close()
+	}()
+	if err := psc.Subscribe("channel_rooms"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := psc.Receive().(redis.Subscription); !ok {
+		t.Fatal("failed to subscribe")
+	}
+
+	proxy.stop()
+
+	rooms := []*pb.Room{{RoomId: 1}, {RoomId: 2}, {RoomId: 3}}
+	for _, room := range rooms {
+		if err := store.RegisterRoom(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.Lock()
+	pending := len(store.pending)
+	store.Unlock()
+	// SET and PUBLISH for each room.
+	if pending != len(rooms)*2 {
+		t.Errorf("commands are not buffered %v", pending)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := proxy.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.stop()
+	defer func() {
+		for _, room := range rooms {
+			_ = store.UnregisterRoom(room)
+		}
+	}()
+
+	for _, want := range rooms {
+		message, ok := psc.Receive().(redis.Message)
+		if !ok {
+			t.Fatal("failed to receive the buffered message")
+		}
 
-	if (id & 0xffff) != 0 {
-		t.Errorf("invalid server id %b", id)
+		room := &pb.Room{}
+		if err := proto.Unmarshal(message.Data[1:], room); err != nil {
+			t.Fatal(err)
+		}
+		if message.Data[0] != registerRoomMessage || room.RoomId != want.RoomId {
+			t.Errorf("invalid message %v %v", message.Data, want)
+		}
 	}
 }
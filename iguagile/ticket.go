@@ -0,0 +1,232 @@
+package iguagile
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TicketRoleCreator is the role of the ticket admits the creator of the room in place of the room token.
+const TicketRoleCreator = "creator"
+
+// Signing algorithms of join tickets
+const (
+	TicketHS256 = "HS256"
+	TicketEdDSA = "EdDSA"
+)
+
+// JoinTicket is the ticket issued by the backend to join the room.
+// It is the JWT signed with the HMAC-SHA256 (HS256) or Ed25519 (EdDSA) key.
+type JoinTicket struct {
+	RoomID int    `json:"room_id"`
+	UserID string `json:"sub"`
+	Role   string `json:"role,omitempty"`
+	// DisplayName and Properties take precedence over the metadata of the handshake.
+	DisplayName string            `json:"name,omitempty"`
+	Properties  map[string]string `json:"props,omitempty"`
+	// ExpiresAt is the unix time the ticket expires at.
+	ExpiresAt int64 `json:"exp"`
+}
+
+type ticketHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// TicketKey is the key to verify join tickets.
+// Either Secret or PublicKey is set.
+type TicketKey struct {
+	// ID is the kid of tickets signed with the key.
+	ID string
+	// Secret is the secret of the HMAC-SHA256.
+	Secret []byte
+	// PublicKey is the public key of the Ed25519.
+	PublicKey ed25519.PublicKey
+}
+
+func (k *TicketKey) algorithm() string {
+	if k.PublicKey != nil {
+		return TicketEdDSA
+	}
+	return TicketHS256
+}
+
+// Errors of join tickets
+var (
+	ErrInvalidTicket = errors.New("invalid join ticket")
+	ErrTicketExpired = errors.New("join ticket expired")
+)
+
+// TicketVerifier verifies join tickets.
+// Keys are rotated by adding the new key and removing the old key after tickets signed with it expire.
+type TicketVerifier struct {
+	keys map[string]TicketKey
+	now  func() time.Time
+	*sync.Mutex
+}
+
+// NewTicketVerifier is TicketVerifier constructed.
+func NewTicketVerifier(keys ...TicketKey) (*TicketVerifier, error) {
+	v := &TicketVerifier{
+		keys:  make(map[string]TicketKey),
+		now:   time.Now,
+		Mutex: &sync.Mutex{},
+	}
+
+	for _, key := range keys {
+		if err := v.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// AddKey adds the key or replaces the key has the same id.
+func (v *TicketVerifier) AddKey(key TicketKey) error {
+	if (len(key.Secret) == 0) == (key.PublicKey == nil) {
+		return fmt.Errorf("either the secret or the public key is required %v", key.ID)
+	}
+	if key.PublicKey != nil && len(key.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size %v %v", key.ID, len(key.PublicKey))
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	v.keys[key.ID] = key
+	return nil
+}
+
+// RemoveKey removes the key.
+func (v *TicketVerifier) RemoveKey(id string) {
+	v.Lock()
+	defer v.Unlock()
+	delete(v.keys, id)
+}
+
+// candidates returns the keys can verify the ticket signed with the algorithm.
+// The ticket without the kid is verified with all keys of the algorithm.
+func (v *TicketVerifier) candidates(header *ticketHeader) []TicketKey {
+	v.Lock()
+	defer v.Unlock()
+
+	if header.KeyID != "" {
+		key, ok := v.keys[header.KeyID]
+		if !ok || key.algorithm() != header.Algorithm {
+			return nil
+		}
+		return []TicketKey{key}
+	}
+
+	keys := make([]TicketKey, 0, len(v.keys))
+	for _, key := range v.keys {
+		if key.algorithm() == header.Algorithm {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Verify verifies the signature and the expiry of the ticket.
+// The algorithm of the ticket must match the type of the key.
+func (v *TicketVerifier) Verify(ticket []byte) (*JoinTicket, error) {
+	parts := strings.Split(string(ticket), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidTicket
+	}
+
+	header := &ticketHeader{}
+	if err := decodeTicketPart(parts[0], header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidTicket
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range v.candidates(header) {
+		if key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w signature", ErrInvalidTicket)
+	}
+
+	claims := &JoinTicket{}
+	if err := decodeTicketPart(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == 0 || claims.UserID == "" {
+		return nil, fmt.Errorf("%w claims", ErrInvalidTicket)
+	}
+	if !v.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrTicketExpired
+	}
+
+	return claims, nil
+}
+
+func (k *TicketKey) verify(signed, signature []byte) bool {
+	if k.PublicKey != nil {
+		return ed25519.Verify(k.PublicKey, signed, signature)
+	}
+
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(signed)
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+func decodeTicketPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrInvalidTicket
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrInvalidTicket
+	}
+	return nil
+}
+
+// SignHMAC returns the ticket signed with the HMAC-SHA256 secret.
+func (t *JoinTicket) SignHMAC(keyID string, secret []byte) ([]byte, error) {
+	return t.sign(&ticketHeader{Algorithm: TicketHS256, KeyID: keyID, Type: "JWT"}, func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	})
+}
+
+// SignEd25519 returns the ticket signed with the Ed25519 private key.
+func (t *JoinTicket) SignEd25519(keyID string, privateKey ed25519.PrivateKey) ([]byte, error) {
+	return t.sign(&ticketHeader{Algorithm: TicketEdDSA, KeyID: keyID, Type: "JWT"}, func(signed []byte) []byte {
+		return ed25519.Sign(privateKey, signed)
+	})
+}
+
+func (t *JoinTicket) sign(header *ticketHeader, sign func(signed []byte) []byte) ([]byte, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature := sign([]byte(signed))
+	return []byte(signed + "." + base64.RawURLEncoding.EncodeToString(signature)), nil
+}
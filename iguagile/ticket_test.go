@@ -0,0 +1,164 @@
+package iguagile
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTicketVerifier(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldSecret := []byte("old secret")
+	newSecret := []byte("new secret")
+	verifier, err := NewTicketVerifier(
+		TicketKey{ID: "old", Secret: oldSecret},
+		TicketKey{ID: "ed", PublicKey: publicKey},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket := &JoinTicket{RoomID: roomID, UserID: "user", Role: "player", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	sign := func(keyID string, secret []byte) []byte {
+		signed, err := ticket.SignHMAC(keyID, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	verified, err := verifier.Verify(sign("old", oldSecret))
+	if err != nil || !reflect.DeepEqual(verified, ticket) {
+		t.Errorf("invalid ticket %v %v", verified, err)
+	}
+
+	signed, err := ticket.SignEd25519("ed", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Error(err)
+	}
+
+	// The Ed25519 public key is not used as the HMAC secret.
+	if _, err := verifier.Verify(sign("ed", publicKey)); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("invalid error %v", err)
+	}
+
+	// Keys are rotated.
+	if err := verifier.AddKey(TicketKey{ID: "new", Secret: newSecret}); err != nil {
+		t.Fatal(err)
+	}
+	verifier.RemoveKey("old")
+	if _, err := verifier.Verify(sign("old", oldSecret)); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("invalid error %v", err)
+	}
+	if _, err := verifier.Verify(sign("new", newSecret)); err != nil {
+		t.Error(err)
+	}
+	if _, err := verifier.Verify(sign("", newSecret)); err != nil {
+		t.Error(err)
+	}
+
+	tampered := sign("new", newSecret)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := verifier.Verify(tampered); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("invalid error %v", err)
+	}
+
+	ticket.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	if _, err := verifier.Verify(sign("new", newSecret)); !errors.Is(err, ErrTicketExpired) {
+		t.Errorf("invalid error %v", err)
+	}
+
+	if err := verifier.AddKey(TicketKey{ID: "none"}); err == nil {
+		t.Error("key without the secret is added")
+	}
+}
+
+func TestJoinTicket(t *testing.T) {
+	server, err := NewRoomServer(RoutingServiceFactory{}, NewMemoryStore(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("secret")
+	server.Tickets, err = NewTicketVerifier(TicketKey{ID: "key", Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := createRoomOn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := func(ticket *JoinTicket) (*HandshakeReply, func()) {
+		signed, err := ticket.SignHMAC("key", secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := &HandshakeRequest{RoomID: roomID, ApplicationName: appName, Version: appVersion, Ticket: signed}
+		conn, reply := joinVersioned(t, server, request.Bytes())
+		return reply, func() {
+			_ = conn.Close()
+		}
+	}
+
+	expiresAt := time.Now().Add(time.Minute).Unix()
+
+	// Only the creator ticket admits the creator.
+	reply, closeConn := join(&JoinTicket{RoomID: roomID, UserID: "guest", ExpiresAt: expiresAt})
+	closeConn()
+	if reply.Status != HandshakeInvalidToken {
+		t.Errorf("invalid reply %v", reply)
+	}
+
+	// The ticket admits the peer without the password and the token.
+	reply, closeConn = join(&JoinTicket{RoomID: roomID, UserID: "creator", Role: TicketRoleCreator, ExpiresAt: expiresAt})
+	defer closeConn()
+	if reply.Status != HandshakeOK {
+		t.Fatalf("invalid reply %v", reply)
+	}
+
+	var identity *Identity
+	if err := room.do(func() {
+		client, err := room.clientManager.Get(reply.ClientID)
+		if err == nil {
+			identity = client.Identity()
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if identity == nil || identity.UserID != "creator" || identity.Role != TicketRoleCreator {
+		t.Errorf("invalid identity %v", identity)
+	}
+
+	reply, closeConn = join(&JoinTicket{RoomID: roomID + 1, UserID: "guest", ExpiresAt: expiresAt})
+	closeConn()
+	if reply.Status != HandshakeInvalidTicket {
+		t.Errorf("invalid reply %v", reply)
+	}
+
+	// The password is required without the ticket only if the ticket is not required.
+	request := &HandshakeRequest{RoomID: roomID, ApplicationName: appName, Version: appVersion, Password: password}
+	conn, reply := joinVersioned(t, server, request.Bytes())
+	_ = conn.Close()
+	if reply.Status != HandshakeOK {
+		t.Errorf("invalid reply %v", reply)
+	}
+
+	server.RequireTicket = true
+	conn, reply = joinVersioned(t, server, request.Bytes())
+	_ = conn.Close()
+	if reply.Status != HandshakeInvalidTicket {
+		t.Errorf("invalid reply %v", reply)
+	}
+}
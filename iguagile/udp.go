@@ -0,0 +1,488 @@
+package iguagile
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDP packet types
+const (
+	udpConnect = iota
+	udpAccept
+	udpData
+	udpAck
+	udpDisconnect
+	udpHeartbeat
+)
+
+const (
+	// Data packet header is type, delivery mode, sequence number, fragment index and fragment count.
+	udpHeaderSize = 6
+	// Maximum packet size small enough to avoid IP fragmentation on common paths.
+	udpMTU             = 1200
+	udpMaxFragmentSize = udpMTU - udpHeaderSize
+	// Maximum number of unacknowledged reliable packets.
+	udpWindowSize        = 512
+	udpResendInterval    = 100 * time.Millisecond
+	udpMaxResend         = 50
+	udpHeartbeatInterval = 2 * time.Second
+	udpTimeout           = 10 * time.Second
+	// Maximum number of received messages waiting to be read.
+	udpMessageBuffer = 256
+)
+
+// ErrUDPConnClosed is returned when the UDP connection is already closed.
+var ErrUDPConnClosed = errors.New("udp connection closed")
+
+// sequenceGreater reports whether a is newer than b considering wraparound.
+func sequenceGreater(a, b uint16) bool {
+	return int16(a-b) > 0
+}
+
+type udpPendingPacket struct {
+	packet []byte
+	sentAt time.Time
+	resent int
+}
+
+type udpFragments struct {
+	seq      uint16
+	parts    [][]byte
+	received int
+}
+
+// udpConn is a connection over UDP.
+// Reliable ordered messages are acknowledged and retransmitted, unreliable messages are not.
+// Messages larger than the MTU are fragmented and reassembled.
+type udpConn struct {
+	conn    net.PacketConn
+	addr    net.Addr
+	onClose func()
+
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	writeMu *sync.Mutex
+
+	sendSeq [3]uint16
+	pending map[uint16]*udpPendingPacket
+
+	reliableExpected uint16
+	reliableReceived map[uint16][]byte
+	reliableMessage  []byte
+	partial          [3]*udpFragments
+	sequencedLast    uint16
+	sequencedInit    bool
+	lastReceived     time.Time
+
+	messages  chan []byte
+	accepted  chan struct{}
+	closed    chan struct{}
+	closeOnce *sync.Once
+	err       error
+}
+
+func newUDPConn(conn net.PacketConn, addr net.Addr, onClose func()) *udpConn {
+	mu := &sync.Mutex{}
+	c := &udpConn{
+		conn:             conn,
+		addr:             addr,
+		onClose:          onClose,
+		mu:               mu,
+		cond:             sync.NewCond(mu),
+		writeMu:          &sync.Mutex{},
+		pending:          make(map[uint16]*udpPendingPacket),
+		reliableReceived: make(map[uint16][]byte),
+		lastReceived:     time.Now(),
+		messages:         make(chan []byte, udpMessageBuffer),
+		accepted:         make(chan struct{}),
+		closed:           make(chan struct{}),
+		closeOnce:        &sync.Once{},
+	}
+
+	go c.resendStart()
+	return c
+}
+
+// handlePacket processes a packet received from the peer.
+// The packet may be reused by the caller after return.
+func (c *udpConn) handlePacket(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.lastReceived = time.Now()
+	c.mu.Unlock()
+
+	switch packet[0] {
+	case udpAccept:
+		c.mu.Lock()
+		select {
+		case <-c.accepted:
+		default:
+			close(c.accepted)
+		}
+		c.mu.Unlock()
+	case udpAck:
+		if len(packet) < 3 {
+			return
+		}
+		c.mu.Lock()
+		delete(c.pending, binary.LittleEndian.Uint16(packet[1:3]))
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	case udpData:
+		if len(packet) < udpHeaderSize {
+			return
+		}
+		c.handleData(packet)
+	case udpDisconnect:
+		c.close(ErrUDPConnClosed, false)
+	}
+}
+
+func (c *udpConn) handleData(packet []byte) {
+	mode := packet[1]
+	seq := binary.LittleEndian.Uint16(packet[2:4])
+	index, count := int(packet[4]), int(packet[5])
+	if count == 0 || index >= count {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch mode {
+	case ReliableOrdered:
+		distance := int16(seq - c.reliableExpected)
+		if distance >= udpWindowSize {
+			return
+		}
+
+		if distance >= 0 {
+			if _, ok := c.reliableReceived[seq]; !ok {
+				c.reliableReceived[seq] = append([]byte{}, packet...)
+			}
+		}
+
+		c.sendAck(seq)
+		c.processReliable()
+	case Unreliable, UnreliableSequenced:
+		if mode == UnreliableSequenced && c.sequencedInit && !sequenceGreater(seq, c.sequencedLast) {
+			return
+		}
+
+		fragments := c.partial[mode]
+		if fragments == nil || fragments.seq != seq {
+			if fragments != nil && !sequenceGreater(seq, fragments.seq) {
+				return
+			}
+			fragments = &udpFragments{seq: seq, parts: make([][]byte, count)}
+			c.partial[mode] = fragments
+		}
+
+		if len(fragments.parts) != count || fragments.parts[index] != nil {
+			return
+		}
+		fragments.parts[index] = append([]byte{}, packet[udpHeaderSize:]...)
+		fragments.received++
+		if fragments.received < count {
+			return
+		}
+
+		c.partial[mode] = nil
+		if mode == UnreliableSequenced {
+			c.sequencedLast = seq
+			c.sequencedInit = true
+		}
+
+		message := make([]byte, 0, count*udpMaxFragmentSize)
+		for _, part := range fragments.parts {
+			message = append(message, part...)
+		}
+
+		select {
+		case c.messages <- message:
+		default:
+			// Unreliable messages are dropped when the reader falls behind.
+		}
+	}
+}
+
+// processReliable delivers reliable packets received in order.
+// The caller must hold the lock.
+func (c *udpConn) processReliable() {
+	for {
+		packet, ok := c.reliableReceived[c.reliableExpected]
+		if !ok {
+			return
+		}
+
+		index, count := int(packet[4]), int(packet[5])
+		if index == count-1 {
+			// Keep the packet until the reader makes room for the message.
+			if len(c.messages) >= cap(c.messages) {
+				return
+			}
+			message := append(c.reliableMessage, packet[udpHeaderSize:]...)
+			c.reliableMessage = nil
+			c.messages <- message
+		} else {
+			c.reliableMessage = append(c.reliableMessage, packet[udpHeaderSize:]...)
+		}
+
+		delete(c.reliableReceived, c.reliableExpected)
+		c.reliableExpected++
+	}
+}
+
+func (c *udpConn) sendAck(seq uint16) {
+	packet := make([]byte, 3)
+	packet[0] = udpAck
+	binary.LittleEndian.PutUint16(packet[1:], seq)
+	_, _ = c.conn.WriteTo(packet, c.addr)
+}
+
+func (c *udpConn) readMessage(buf []byte) (int, error) {
+	select {
+	case message := <-c.messages:
+		c.mu.Lock()
+		c.processReliable()
+		c.mu.Unlock()
+		return copy(buf, message), nil
+	case <-c.closed:
+		return 0, c.err
+	}
+}
+
+func (c *udpConn) writeMessage(message []byte, mode byte) error {
+	if mode > UnreliableSequenced {
+		mode = ReliableOrdered
+	}
+
+	count := (len(message) + udpMaxFragmentSize - 1) / udpMaxFragmentSize
+	if count == 0 {
+		count = 1
+	}
+
+	// Fragments of a message must not interleave with other messages.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	seq := c.sendSeq[mode]
+	if mode != ReliableOrdered {
+		c.sendSeq[mode]++
+	}
+	c.mu.Unlock()
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * udpMaxFragmentSize
+		if end > len(message) {
+			end = len(message)
+		}
+		fragment := message[i*udpMaxFragmentSize : end]
+
+		packet := make([]byte, udpHeaderSize+len(fragment))
+		packet[0] = udpData
+		packet[1] = mode
+		packet[4] = byte(i)
+		packet[5] = byte(count)
+		copy(packet[udpHeaderSize:], fragment)
+
+		if mode == ReliableOrdered {
+			c.mu.Lock()
+			for len(c.pending) >= udpWindowSize && !c.isClosed() {
+				c.cond.Wait()
+			}
+			if c.isClosed() {
+				c.mu.Unlock()
+				return c.err
+			}
+			seq = c.sendSeq[mode]
+			c.sendSeq[mode]++
+			binary.LittleEndian.PutUint16(packet[2:4], seq)
+			c.pending[seq] = &udpPendingPacket{packet: packet, sentAt: time.Now()}
+			c.mu.Unlock()
+		} else {
+			binary.LittleEndian.PutUint16(packet[2:4], seq)
+		}
+
+		if _, err := c.conn.WriteTo(packet, c.addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *udpConn) resendStart() {
+	ticker := time.NewTicker(udpResendInterval)
+	defer ticker.Stop()
+	heartbeatAt := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if now.Sub(heartbeatAt) >= udpHeartbeatInterval {
+				heartbeatAt = now
+				_, _ = c.conn.WriteTo([]byte{udpHeartbeat}, c.addr)
+			}
+
+			c.mu.Lock()
+			if now.Sub(c.lastReceived) > udpTimeout {
+				c.mu.Unlock()
+				c.close(errors.New("udp connection timed out"), true)
+				return
+			}
+
+			var err error
+			for _, pending := range c.pending {
+				if now.Sub(pending.sentAt) < udpResendInterval {
+					continue
+				}
+				if pending.resent >= udpMaxResend {
+					err = errors.New("udp packet was not acknowledged")
+					break
+				}
+				pending.resent++
+				pending.sentAt = now
+				_, _ = c.conn.WriteTo(pending.packet, c.addr)
+			}
+			c.mu.Unlock()
+
+			if err != nil {
+				c.close(err, true)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *udpConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *udpConn) close(err error, notify bool) {
+	c.closeOnce.Do(func() {
+		if notify {
+			_, _ = c.conn.WriteTo([]byte{udpDisconnect}, c.addr)
+		}
+
+		c.mu.Lock()
+		c.err = err
+		close(c.closed)
+		c.cond.Broadcast()
+		c.mu.Unlock()
+
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
+
+// Read reads a message.
+func (c *udpConn) Read(buf []byte) (int, error) {
+	return c.readMessage(buf)
+}
+
+// Write writes a reliable message.
+func (c *udpConn) Write(message []byte) (int, error) {
+	if err := c.writeMessage(message, ReliableOrdered); err != nil {
+		return 0, err
+	}
+	return len(message), nil
+}
+
+// Close closes the connection and notifies the peer.
+func (c *udpConn) Close() error {
+	c.close(ErrUDPConnClosed, true)
+	return nil
+}
+
+// udpListener demultiplexes packets into connections by the remote address.
+type udpListener struct {
+	conn  net.PacketConn
+	conns map[string]*udpConn
+	*sync.Mutex
+}
+
+func (l *udpListener) remove(addr net.Addr) {
+	l.Lock()
+	delete(l.conns, addr.String())
+	l.Unlock()
+}
+
+// RunUDP starts api server and room server accepts UDP connections.
+func (s *RoomServer) RunUDP(roomConn net.PacketConn, apiPort int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := s.start(ctx, apiPort, roomConn); err != nil {
+		return err
+	}
+
+	listener := &udpListener{
+		conn:  roomConn,
+		conns: make(map[string]*udpConn),
+		Mutex: &sync.Mutex{},
+	}
+
+	buf := make([]byte, udpMTU)
+	for {
+		n, addr, err := roomConn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.log().Error("failed to read the packet", "error", err)
+			continue
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		listener.Lock()
+		conn, ok := listener.conns[addr.String()]
+		if !ok && buf[0] == udpConnect {
+			conn = newUDPConn(roomConn, addr, func() {
+				listener.remove(addr)
+			})
+			listener.conns[addr.String()] = conn
+		}
+		listener.Unlock()
+
+		if conn == nil {
+			continue
+		}
+
+		if buf[0] == udpConnect {
+			// The accept packet is sent again when the peer retries connecting.
+			_, _ = roomConn.WriteTo([]byte{udpAccept}, addr)
+			if !ok {
+				go func() {
+					if err := s.Serve(conn); err != nil {
+						_ = conn.Close()
+					}
+				}()
+			}
+			continue
+		}
+
+		conn.handlePacket(buf[:n])
+	}
+}
@@ -0,0 +1,179 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func dialUDP(address string) (*udpConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	packetConn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newUDPConn(packetConn, addr, func() {
+		_ = packetConn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, udpMTU)
+		for {
+			n, _, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.handlePacket(buf[:n])
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		if _, err := packetConn.WriteTo([]byte{udpConnect}, addr); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-conn.accepted:
+			return conn, nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	_ = conn.Close()
+	return nil, errors.New("udp handshake timed out")
+}
+
+func dataPacket(mode byte, seq uint16, index, count byte, payload []byte) []byte {
+	packet := make([]byte, udpHeaderSize, udpHeaderSize+len(payload))
+	packet[0] = udpData
+	packet[1] = mode
+	binary.LittleEndian.PutUint16(packet[2:4], seq)
+	packet[4] = index
+	packet[5] = count
+	return append(packet, payload...)
+}
+
+func TestUDPRelayService(t *testing.T) {
+	if err := setupServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoom(); err != nil {
+		t.Fatal(err)
+	}
+
+	packetConn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = packetConn.Close()
+	}()
+
+	go func() {
+		_ = roomServer.RunUDP(packetConn, 0)
+	}()
+
+	conn, err := dialUDP(packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := verifyMessages(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is larger than the MTU and delivered in reliable ordered mode.
+	large := make([]byte, udpMTU*5)
+	for i := range large {
+		large[i] = byte(i)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for _, data := range [][]byte{large, {1, 2, 3}} {
+		if err := conn.writeMessage(data, ReliableOrdered); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := conn.readMessage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buf[:n], data) {
+			t.Errorf("invalid data length %v, %v", n, len(data))
+		}
+	}
+}
+
+func verifyMessages(conn messageConn) error {
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, roomID)
+	for _, data := range [][]byte{id, []byte(appName), []byte(appVersion), []byte(password), roomToken} {
+		if err := conn.writeMessage(data, ReliableOrdered); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestUDPConnDelivery(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = packetConn.Close()
+	}()
+
+	conn := newUDPConn(packetConn, packetConn.LocalAddr(), nil)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	packets := [][]byte{
+		// Reliable packets are delivered in order and fragments are reassembled.
+		dataPacket(ReliableOrdered, 1, 0, 2, []byte("b")),
+		dataPacket(ReliableOrdered, 0, 0, 1, []byte("a")),
+		dataPacket(ReliableOrdered, 0, 0, 1, []byte("a")),
+		dataPacket(ReliableOrdered, 2, 1, 2, []byte("c")),
+		// Stale sequenced messages are dropped.
+		dataPacket(UnreliableSequenced, 5, 0, 1, []byte("d")),
+		dataPacket(UnreliableSequenced, 3, 0, 1, []byte("e")),
+		dataPacket(Unreliable, 1, 1, 2, []byte("g")),
+		dataPacket(Unreliable, 1, 0, 2, []byte("f")),
+	}
+	for _, packet := range packets {
+		conn.handlePacket(packet)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for _, want := range []string{"a", "bc", "d", "fg"} {
+		n, err := conn.readMessage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf[:n]) != want {
+			t.Errorf("invalid message %s, %s", buf[:n], want)
+		}
+	}
+
+	select {
+	case message := <-conn.messages:
+		t.Errorf("unexpected message %s", message)
+	default:
+	}
+}
@@ -0,0 +1,83 @@
+package iguagile
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// webSocketConn maps one binary WebSocket frame to one iguagile message.
+type webSocketConn struct {
+	*websocket.Conn
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newWebSocketConn(ws *websocket.Conn) *webSocketConn {
+	ws.PayloadType = websocket.BinaryFrame
+	ws.MaxPayloadBytes = maxMessageSize
+	return &webSocketConn{
+		Conn:      ws,
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+func (c *webSocketConn) readMessage(buf []byte) (int, error) {
+	var message []byte
+	if err := websocket.Message.Receive(c.Conn, &message); err != nil {
+		return 0, err
+	}
+
+	return copy(buf, message), nil
+}
+
+func (c *webSocketConn) writeMessage(message []byte, _ byte) error {
+	return websocket.Message.Send(c.Conn, message)
+}
+
+// Close closes the connection and releases the handler serving it.
+func (c *webSocketConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return err
+}
+
+// WebSocketHandler returns a http.Handler accepts WebSocket connections.
+// The handshake is the same as Serve, but each message is sent in a binary frame without size prefix.
+func (s *RoomServer) WebSocketHandler() http.Handler {
+	// The origin is not checked because game clients do not always send it.
+	return websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			conn := newWebSocketConn(ws)
+			if err := s.Serve(conn); err != nil {
+				_ = conn.Close()
+				return
+			}
+
+			// The connection is closed when the handler returns, so wait for the room to close it.
+			<-conn.closed
+		},
+	}
+}
+
+// RunWebSocket starts api server and room server accepts WebSocket connections.
+func (s *RoomServer) RunWebSocket(roomListener net.Listener, apiPort int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := s.start(ctx, apiPort, roomListener); err != nil {
+		return err
+	}
+
+	err := http.Serve(roomListener, s.WebSocketHandler())
+	if s.isClosed() {
+		return ErrServerClosed
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketRelayService(t *testing.T) {
+	if err := setupServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createRoom(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(roomServer.WebSocketHandler())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, roomID)
+	for _, data := range [][]byte{id, []byte(appName), []byte(appVersion), []byte(password), roomToken, testData} {
+		if err := websocket.Message.Send(conn, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received []byte
+	if err := websocket.Message.Receive(conn, &received); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, testData) {
+		t.Errorf("invalid data %v, %v", received, testData)
+	}
+}